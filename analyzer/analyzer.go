@@ -0,0 +1,91 @@
+// Package analyzer provides a go/analysis Analyzer that reports files
+// whose formatting differs from nofmt's, so nofmt can be run by go vet
+// or golangci-lint in place of a gofmt check.
+package analyzer
+
+import (
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/debspencer/nofmt/parser"
+	"golang.org/x/tools/go/analysis"
+)
+
+// Analyzer reports files that are not formatted by nofmt, with a
+// suggested fix that formats them
+var Analyzer = &analysis.Analyzer{
+	Name: "nofmt",
+	Doc:  "report files whose formatting differs from nofmt's, which honors // go:nofmt blocks",
+	Run:  run,
+}
+
+// formatter is the formatter program with arguments, see parser.NewFormatter
+var formatter string
+
+func init() {
+	Analyzer.Flags.StringVar(&formatter, "F", "gofmt %f", "specify formatter 'program args' (filename will be appended unless %f is used)")
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, f := range pass.Files {
+		tf := pass.Fset.File(f.Pos())
+		if tf == nil || filepath.Ext(tf.Name()) != ".go" {
+			continue // cgo and other generated sources
+		}
+		err := check(pass, tf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// check formats a single file, reporting it if it changes
+func check(pass *analysis.Pass, tf *token.File) error {
+	src, err := ioutil.ReadFile(tf.Name())
+	if err != nil {
+		return err
+	}
+
+	res, err := parser.NewFormatter(formatter).Format(src, tf.Name())
+	if err != nil {
+		return err
+	}
+
+	edits := parser.Edits(src, res.Output)
+	if len(edits) == 0 {
+		return nil
+	}
+
+	textEdits := make([]analysis.TextEdit, 0, len(edits))
+	for _, e := range edits {
+		textEdits = append(textEdits, analysis.TextEdit{
+			Pos:     linePos(tf, e.Start),
+			End:     linePos(tf, e.End),
+			NewText: []byte(strings.Join(e.Lines, "")),
+		})
+	}
+
+	pos := textEdits[0].Pos
+	pass.Report(analysis.Diagnostic{
+		Pos:     pos,
+		End:     pos,
+		Message: "file is not nofmt-ed",
+		SuggestedFixes: []analysis.SuggestedFix{{
+			Message:   "Format with nofmt",
+			TextEdits: textEdits,
+		}},
+	})
+	return nil
+}
+
+// linePos returns the position of the start of the 0 based line, or the
+// end of the file for lines past the end
+func linePos(tf *token.File, line int) token.Pos {
+	if line >= tf.LineCount() {
+		return tf.Pos(tf.Size())
+	}
+	return tf.LineStart(line + 1)
+}
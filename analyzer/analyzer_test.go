@@ -0,0 +1,50 @@
+package analyzer
+
+import (
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	a := assert.New(t)
+	dir := analysistest.TestData()
+	results := analysistest.Run(t, dir, Analyzer, "a")
+	if !a.Len(results, 1) || !a.Len(results[0].Diagnostics, 1) {
+		return
+	}
+
+	// analysistest.RunWithSuggestedFixes runs gofmt on the result, which
+	// would format the go:nofmt block, so the fix is checked here
+	fset := results[0].Action.Package.Fset
+	fix := results[0].Diagnostics[0].SuggestedFixes
+	if !a.Len(fix, 1) {
+		return
+	}
+	edits := fix[0].TextEdits
+	sort.Slice(edits, func(i, j int) bool { return edits[i].Pos < edits[j].Pos })
+
+	file := fset.File(edits[0].Pos)
+	src, err := ioutil.ReadFile(file.Name())
+	a.NoError(err)
+	golden, err := ioutil.ReadFile(filepath.Join(dir, "src", "a", "a.go.golden"))
+	a.NoError(err)
+	a.Equal(string(golden), string(applyEdits(file.Offset, src, edits)))
+}
+
+func applyEdits(offset func(p token.Pos) int, src []byte, edits []analysis.TextEdit) []byte {
+	var out []byte
+	n := 0
+	for _, e := range edits {
+		out = append(out, src[n:offset(e.Pos)]...)
+		out = append(out, e.NewText...)
+		n = offset(e.End)
+	}
+	return append(out, src[n:]...)
+}
@@ -0,0 +1,14 @@
+package a
+
+var x   int // want "file is not nofmt-ed"
+
+// go:nofmt
+var table = []int{
+    1,   2,
+    30,  4,
+}
+
+// go:fmt
+func f()   {
+  println(x, table)
+}
@@ -0,0 +1,12 @@
+package a
+
+// go:nofmt
+var grid = [][]int{
+    {1,  2},
+    {30, 4},
+}
+
+// go:fmt
+func g() {
+	println(grid)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// cacheVersion changes when the cache key changes
+const cacheVersion = 1
+
+// cache records the files that are already formatted, by a hash of their
+// contents and of everything else that affects the formatting, so they
+// can be skipped without running the formatter.  Only clean files are
+// recorded, each by an empty file named by its key.
+type cache struct {
+	dir  string
+	salt []byte // hash of the formatter, its environment and nofmt itself
+}
+
+// cacheDir returns the directory of the cache, $NOFMTCACHE or nofmt in
+// the user cache directory
+func cacheDir() (string, error) {
+	if dir := os.Getenv("NOFMTCACHE"); len(dir) > 0 {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nofmt"), nil
+}
+
+// newCache returns the cache for files formatted by command with env and
+// the -embedded formatters embedded, and with -force-lf if forceLF is set
+func newCache(command []string, env []string, embedded []string, forceLF bool) (*cache, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no formatter")
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "nofmt cache %d\n", cacheVersion)
+
+	// a new build of nofmt or of the formatter may format differently
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	program, err := exec.LookPath(command[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, exe := range []string{self, program} {
+		st, err := os.Stat(exe)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "%s %d %d\n", exe, st.Size(), st.ModTime().UnixNano())
+	}
+
+	for _, s := range command {
+		fmt.Fprintf(h, "arg %q\n", s)
+	}
+	for _, s := range env {
+		fmt.Fprintf(h, "env %q\n", s)
+	}
+	for _, s := range embedded {
+		fmt.Fprintf(h, "embedded %q\n", s)
+	}
+	fmt.Fprintf(h, "force-lf %t\n", forceLF)
+	return &cache{dir: dir, salt: h.Sum(nil)}, nil
+}
+
+// key returns the key of the source src of file.  The formatter runs in
+// the directory of file and may be given its name, which can change its
+// output.
+func (c *cache) key(src []byte, file string) string {
+	path, _ := filepath.Abs(file)
+
+	h := sha256.New()
+	h.Write(c.salt)
+	fmt.Fprintf(h, "file %q\n", path)
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// clean reports if the source of key is known to be formatted
+func (c *cache) clean(key string) bool {
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+// markClean records that the source of key is formatted.  The cache is
+// only an optimization, so errors are ignored.
+func (c *cache) markClean(key string) {
+	path := c.path(key)
+	if os.MkdirAll(filepath.Dir(path), 0755) == nil {
+		ioutil.WriteFile(path, nil, 0644)
+	}
+}
+
+// cleanCache removes the entries of the cache.  Only the directories
+// the cache creates are removed, in case the cache directory is shared.
+func cleanCache(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || len(e.Name()) != 2 {
+			continue
+		}
+		if _, err := hex.DecodeString(e.Name()); err != nil {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheMain runs the cache clean and cache dir commands
+func cacheMain(args []string) int {
+	if len(args) == 2 {
+		dir, err := cacheDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cache: %s\n", err)
+			return 2
+		}
+		switch args[1] {
+		case "clean":
+			err = cleanCache(dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cache clean: %s\n", err)
+				return 2
+			}
+			return 0
+		case "dir":
+			fmt.Println(dir)
+			return 0
+		}
+	}
+	fmt.Fprintln(os.Stderr, "usage: nofmt cache clean|dir")
+	return 2
+}
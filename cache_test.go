@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	a := assert.New(t)
+	t.Setenv("NOFMTCACHE", t.TempDir())
+
+	c, err := newCache([]string{"gofmt", "%f"}, nil, nil, false)
+	a.NoError(err)
+
+	src := []byte("package a\n")
+	key := c.key(src, "a/a.go")
+	a.Equal(key, c.key(src, "a/a.go"))
+	a.NotEqual(key, c.key([]byte("package b\n"), "a/a.go"))
+	a.NotEqual(key, c.key(src, "b/a.go"))
+	a.NotEqual(key, c.key(src, "a/b.go"))
+
+	for _, other := range []struct {
+		command  []string
+		env      []string
+		embedded []string
+		forceLF  bool
+	}{
+		{command: []string{"gofmt", "-s", "%f"}},
+		{command: []string{"gofmt", "%f"}, env: []string{"GOFLAGS=-mod=mod"}},
+		{command: []string{"gofmt", "%f"}, embedded: []string{"sql=pg_format"}},
+		{command: []string{"gofmt", "%f"}, forceLF: true},
+	} {
+		oc, err := newCache(other.command, other.env, other.embedded, other.forceLF)
+		a.NoError(err)
+		a.NotEqual(key, oc.key(src, "a/a.go"))
+	}
+
+	_, err = newCache([]string{"no-such-formatter"}, nil, nil, false)
+	a.Error(err)
+
+	a.False(c.clean(key))
+	c.markClean(key)
+	a.True(c.clean(key))
+}
+
+func TestMainCache(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	t.Setenv("NOFMTCACHE", filepath.Join(dir, "cache"))
+	exit = func(int) {}
+
+	// the formatter logs each run
+	log := filepath.Join(dir, "log")
+	script := filepath.Join(dir, "fmt.sh")
+	a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\necho run >> "+log+"\nexec gofmt \"$@\"\n"), 0755))
+	runs := func() int {
+		data, _ := ioutil.ReadFile(log)
+		return strings.Count(string(data), "run")
+	}
+
+	clean := filepath.Join(dir, "clean.go")
+	writeFile(t, clean, "package a\n\nvar a int\n")
+	dirty := filepath.Join(dir, "dirty.go")
+	writeFile(t, dirty, "package a\n\nvar b   int\n")
+
+	os.Args = []string{"nofmt", "-l", "-F", script, clean, dirty}
+	main()
+	a.Equal(2, runs())
+
+	// only the file that is not formatted is run again
+	main()
+	a.Equal(3, runs())
+
+	// printing a clean file from the cache
+	stdout, w, err := os.Pipe()
+	a.NoError(err)
+	out := os.Stdout
+	os.Stdout = w
+	os.Args = []string{"nofmt", "-F", script, clean}
+	main()
+	os.Stdout = out
+	w.Close()
+	data, err := ioutil.ReadAll(stdout)
+	a.NoError(err)
+	a.Equal("package a\n\nvar a int\n", string(data))
+	a.Equal(3, runs())
+
+	os.Args = []string{"nofmt", "-l", "-cache=off", "-F", script, clean}
+	main()
+	a.Equal(4, runs())
+
+	// a change to the file is a new entry
+	writeFile(t, clean, "package a\n\nvar c int\n")
+	os.Args = []string{"nofmt", "-l", "-F", script, clean}
+	main()
+	a.Equal(5, runs())
+
+	a.Equal(0, cacheMain([]string{"cache", "clean"}))
+	os.Args = []string{"nofmt", "-l", "-F", script, clean}
+	main()
+	a.Equal(6, runs())
+}
+
+func TestCleanCache(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	t.Setenv("NOFMTCACHE", dir)
+
+	writeFile(t, filepath.Join(dir, "ab", "abcd"), "")
+	writeFile(t, filepath.Join(dir, "other", "file"), "")
+	writeFile(t, filepath.Join(dir, "zz", "file"), "")
+
+	a.Equal(0, cacheMain([]string{"cache", "clean"}))
+	_, err := os.Stat(filepath.Join(dir, "ab"))
+	a.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "other", "file"))
+	a.NoError(err)
+	_, err = os.Stat(filepath.Join(dir, "zz", "file"))
+	a.NoError(err)
+
+	a.Equal(0, cacheMain([]string{"cache", "clean"}))
+	t.Setenv("NOFMTCACHE", filepath.Join(dir, "missing"))
+	a.Equal(0, cacheMain([]string{"cache", "clean"}))
+	a.Equal(2, cacheMain([]string{"cache"}))
+	a.Equal(2, cacheMain([]string{"cache", "bogus"}))
+}
@@ -0,0 +1,16 @@
+// Command nofmtvet runs the nofmt analyzer, on its own or from go vet:
+//
+//	go vet -vettool=$(which nofmtvet) ./...
+//
+// Fix the files it reports with nofmt -w rather than -fix, which runs
+// gofmt on the fixed files.
+package main
+
+import (
+	"github.com/debspencer/nofmt/analyzer"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// freezeMain adds pragmas around the hand aligned code of files, so that
+// formatting them keeps it
+func freezeMain(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	list := f.Bool("l", false, "list files that would be changed, without changing them")
+	formatter := f.String("F", "gofmt %f", "specify formatter 'program args' (filename will be appended unless %f is used)")
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nofmt freeze [-l] [-F <fmter>] file|dir ...\n")
+		f.PrintDefaults()
+	}
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+	if f.NArg() == 0 {
+		f.Usage()
+		return 2
+	}
+
+	command, err := parser.ParseCommand(*formatter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "freeze: bad formatter: %s\n", err)
+		return 2
+	}
+	fmter := parser.NewFormatterArgs(command)
+
+	files := make(chan string)
+	go func() {
+		walk(files, f.Args(), nil)
+		close(files)
+	}()
+
+	exitCode := 0
+	for file := range files {
+		changed, err := freezeFile(fmter, file, !*list)
+		if err != nil {
+			var synErr *parser.SyntaxError
+			if errors.As(err, &synErr) {
+				printSyntaxError(synErr, file)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			}
+			exitCode = 2
+			continue
+		}
+		if changed && *list {
+			fmt.Println(file)
+		}
+	}
+	return exitCode
+}
+
+// freezeFile adds pragmas around the hand aligned code of file, writing
+// it if write is set, and reports if it changed
+func freezeFile(fmter *parser.Formatter, file string, write bool) (bool, error) {
+	st, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+
+	frozen, n, err := fmter.Freeze(context.Background(), src, file)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 || bytes.Equal(frozen, src) {
+		return false, nil
+	}
+	if write {
+		err = ioutil.WriteFile(file, frozen, st.Mode())
+	}
+	return true, err
+}
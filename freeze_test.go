@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeze(t *testing.T) {
+	a := assert.New(t)
+	flagErrorHandling = flag.ContinueOnError
+
+	dir := t.TempDir()
+	aligned := filepath.Join(dir, "aligned.go")
+	src := "package a\n\nvar (\n\ta     = 1\n\tbcd = 2\n)\n"
+	a.NoError(ioutil.WriteFile(aligned, []byte(src), 0600))
+	formatted := filepath.Join(dir, "formatted.go")
+	a.NoError(ioutil.WriteFile(formatted, []byte("package a\n\nvar c = 3\n"), 0644))
+
+	// -l changes nothing
+	a.Equal(0, freezeMain([]string{"freeze", "-l", dir}))
+	data, err := ioutil.ReadFile(aligned)
+	a.NoError(err)
+	a.Equal(src, string(data))
+
+	a.Equal(0, freezeMain([]string{"freeze", dir}))
+	data, err = ioutil.ReadFile(aligned)
+	a.NoError(err)
+	a.Equal("package a\n\nvar (\n\t// go:nofmt\n\ta     = 1\n\t// go:fmt\n\tbcd = 2\n)\n", string(data))
+	st, err := os.Stat(aligned)
+	a.NoError(err)
+	a.Equal(os.FileMode(0600), st.Mode())
+	data, err = ioutil.ReadFile(formatted)
+	a.NoError(err)
+	a.Equal("package a\n\nvar c = 3\n", string(data))
+
+	bad := filepath.Join(dir, "bad.go")
+	a.NoError(ioutil.WriteFile(bad, []byte("package a\n\nvar b = \n"), 0644))
+	a.Equal(2, freezeMain([]string{"freeze", bad}))
+
+	a.Equal(2, freezeMain([]string{"freeze"}))
+	a.Equal(2, freezeMain([]string{"freeze", "-no-such-flag"}))
+	a.Equal(2, freezeMain([]string{"freeze", "-F", `"gofmt`, dir}))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// git runs a git command returning its output
+func git(args ...string) ([]byte, error) {
+	return gitStdin(nil, args...)
+}
+
+// gitStdin runs a git command with in as its standard input
+func gitStdin(in []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	if in != nil {
+		cmd.Stdin = bytes.NewReader(in)
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) == 0 {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitChangedLines returns the lines of each Go file that have changed
+// since base, or in the index if staged is set.  If base is empty the
+// changes are relative to the index, or HEAD when staged.  paths limit
+// the files that are looked at.  File names are relative to the current
+// directory.
+func gitChangedLines(base string, staged bool, paths []string) (map[string][]parser.LineRange, error) {
+	args := []string{"diff", "-U0", "--no-color", "--no-ext-diff", "--relative", "--src-prefix=a/", "--dst-prefix=b/"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	if len(base) > 0 {
+		args = append(args, base)
+	}
+	args = append(args, "--")
+	args = append(args, paths...)
+
+	out, err := git(args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseDiffHunks(out)
+}
+
+// gitUnstagedFiles returns the files with changes that are not in the
+// index, named as by gitChangedLines.  paths limit the files that are
+// looked at.
+func gitUnstagedFiles(paths []string) (map[string]bool, error) {
+	args := []string{"diff", "--name-only", "-z", "--no-ext-diff", "--relative", "--"}
+	out, err := git(append(args, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	unstaged := make(map[string]bool)
+	for _, file := range strings.Split(string(out), "\x00") {
+		if len(file) > 0 {
+			unstaged[filepath.Clean(filepath.FromSlash(file))] = true
+		}
+	}
+	return unstaged, nil
+}
+
+// parseDiffHunks parses the output of git diff -U0 into the changed lines
+// of each Go file in the new version
+func parseDiffHunks(diff []byte) (map[string][]parser.LineRange, error) {
+	changed := make(map[string][]parser.LineRange)
+
+	file := ""
+	scanner := bufio.NewScanner(bytes.NewReader(diff))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			// git ends a name holding a space with a tab
+			file = strings.TrimSuffix(strings.TrimPrefix(line, "+++ "), "\t")
+			if strings.HasPrefix(file, `"`) {
+				// git quotes names with unusual characters
+				unquoted, err := strconv.Unquote(file)
+				if err != nil {
+					return nil, fmt.Errorf("bad file name %s", file)
+				}
+				file = unquoted
+			}
+			if !strings.HasPrefix(file, "b/") || filepath.Ext(file) != ".go" {
+				file = "" // deleted or not a Go file
+				continue
+			}
+			file = filepath.Clean(filepath.FromSlash(file[2:]))
+
+		case strings.HasPrefix(line, "@@ ") && len(file) > 0:
+			// @@ -start,count +start,count @@
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+				return nil, fmt.Errorf("bad hunk %q", line)
+			}
+			startCount := strings.SplitN(fields[2][1:], ",", 2)
+			start, err := strconv.Atoi(startCount[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad hunk %q", line)
+			}
+			count := 1
+			if len(startCount) > 1 {
+				count, err = strconv.Atoi(startCount[1])
+				if err != nil {
+					return nil, fmt.Errorf("bad hunk %q", line)
+				}
+			}
+			if count == 0 {
+				continue // only lines removed
+			}
+			changed[file] = append(changed[file], parser.LineRange{Start: start, End: start + count - 1})
+		}
+	}
+	return changed, scanner.Err()
+}
+
+// changedFiles returns the files of changed in order
+func changedFiles(changed map[string][]parser.LineRange) []string {
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/debspencer/nofmt/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// gitRepo creates a temporary git repository and changes into it.
+// The returned func removes it and changes back.
+func gitRepo(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "nofmt-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, "init", "-q")
+	gitRun(t, "config", "user.email", "nofmt@example.com")
+	gitRun(t, "config", "user.name", "nofmt")
+	return func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	}
+}
+
+func gitRun(t *testing.T, args ...string) {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s %s", args, err, out)
+	}
+}
+
+func writeFile(t *testing.T, name string, data string) {
+	os.MkdirAll(filepath.Dir(name), 0755)
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitChangedLines(t *testing.T) {
+	a := assert.New(t)
+	defer gitRepo(t)()
+
+	writeFile(t, "a.go", "package a\n\nvar a   int\nvar b   int\nvar c   int\n")
+	writeFile(t, "sub/b.go", "package sub\n\nvar a   int\n")
+	writeFile(t, "notes.txt", "notes\n")
+	gitRun(t, "add", ".")
+	gitRun(t, "commit", "-q", "-m", "one")
+
+	writeFile(t, "a.go", "package a\n\nvar a   int\nvar bb   int\nvar c   int\nvar d   int\nvar e   int\n")
+	writeFile(t, "sub/b.go", "package sub\n")
+	writeFile(t, "notes.txt", "more notes\n")
+
+	changed, err := gitChangedLines("HEAD", false, nil)
+	a.NoError(err)
+	a.Equal(map[string][]parser.LineRange{
+		"a.go": {{Start: 4, End: 4}, {Start: 6, End: 7}},
+	}, changed)
+
+	// git ends the name holding a space with a tab
+	writeFile(t, "my file.go", "package a\n\nvar f   int\n")
+	gitRun(t, "add", "my file.go")
+	changed, err = gitChangedLines("", true, nil)
+	a.NoError(err)
+	a.Equal(map[string][]parser.LineRange{
+		"my file.go": {{Start: 1, End: 3}},
+	}, changed)
+	gitRun(t, "rm", "-q", "--cached", "my file.go")
+	a.NoError(os.Remove("my file.go"))
+
+	// nothing staged
+	changed, err = gitChangedLines("", true, nil)
+	a.NoError(err)
+	a.Empty(changed)
+
+	gitRun(t, "add", "a.go")
+	writeFile(t, "sub/c.go", "package sub\n\nvar c   int\n")
+	gitRun(t, "add", "sub/c.go")
+
+	changed, err = gitChangedLines("", true, nil)
+	a.NoError(err)
+	a.Equal(map[string][]parser.LineRange{
+		"a.go":                       {{Start: 4, End: 4}, {Start: 6, End: 7}},
+		filepath.Join("sub", "c.go"): {{Start: 1, End: 3}},
+	}, changed)
+
+	changed, err = gitChangedLines("", true, []string{"sub"})
+	a.NoError(err)
+	a.Equal([]string{filepath.Join("sub", "c.go")}, changedFiles(changed))
+
+	// paths are relative to the current directory
+	a.NoError(os.Chdir("sub"))
+	changed, err = gitChangedLines("", true, nil)
+	a.NoError(err)
+	a.Equal([]string{"c.go"}, changedFiles(changed))
+	a.NoError(os.Chdir(".."))
+
+	_, err = gitChangedLines("no-such-revision", false, nil)
+	a.Error(err)
+}
+
+func TestMainDiffBase(t *testing.T) {
+	a := assert.New(t)
+	defer gitRepo(t)()
+	t.Setenv("NOFMTCACHE", t.TempDir())
+
+	exit = func(int) {}
+
+	writeFile(t, "a.go", "package a\n\nvar a   int\nvar b   int\n")
+	gitRun(t, "add", ".")
+	gitRun(t, "commit", "-q", "-m", "one")
+	writeFile(t, "a.go", "package a\n\nvar a   int\nvar b   int\nvar c   int\n")
+
+	os.Args = []string{"nofmt", "-w", "-diff-base", "HEAD"}
+	main()
+
+	data, err := ioutil.ReadFile("a.go")
+	a.NoError(err)
+	a.Equal("package a\n\nvar a   int\nvar b   int\nvar c int\n", string(data))
+}
+
+func TestMainStaged(t *testing.T) {
+	a := assert.New(t)
+	defer gitRepo(t)()
+	t.Setenv("NOFMTCACHE", t.TempDir())
+
+	status := 0
+	exit = func(s int) { status = s }
+
+	writeFile(t, "a.go", "package a\n\nvar a   int\nvar b   int\n")
+	writeFile(t, "b.go", "package a\n\nvar c   int\n")
+	gitRun(t, "add", ".")
+	gitRun(t, "commit", "-q", "-m", "one")
+
+	// a staged hunk in each, with an unstaged edit above it in a.go
+	writeFile(t, "a.go", "package a\n\nvar a   int\nvar b   int\nvar s   int\n")
+	writeFile(t, "b.go", "package a\n\nvar c   int\nvar s   int\n")
+	gitRun(t, "add", ".")
+	writeFile(t, "a.go", "package a\n\nvar x   int\nvar y   int\nvar a   int\nvar b   int\nvar s   int\n")
+
+	os.Args = []string{"nofmt", "-w", "-staged"}
+	main()
+	a.Equal(2, status)
+
+	data, err := ioutil.ReadFile("a.go")
+	a.NoError(err)
+	a.Equal("package a\n\nvar x   int\nvar y   int\nvar a   int\nvar b   int\nvar s   int\n", string(data))
+	data, err = ioutil.ReadFile("b.go")
+	a.NoError(err)
+	a.Equal("package a\n\nvar c   int\nvar s int\n", string(data))
+
+	// the formatted b.go is not staged
+	unstaged, err := gitUnstagedFiles(nil)
+	a.NoError(err)
+	a.Equal(map[string]bool{"a.go": true, "b.go": true}, unstaged)
+}
+
+func TestParseDiffHunks(t *testing.T) {
+	a := assert.New(t)
+
+	changed, err := parseDiffHunks([]byte("+++ \"b/a\\tb.go\"\n@@ -1 +1 @@\n+++ /dev/null\n@@ -1,2 +0,0 @@\n"))
+	a.NoError(err)
+	a.Equal(map[string][]parser.LineRange{"a\tb.go": {{Start: 1, End: 1}}}, changed)
+
+	changed, err = parseDiffHunks([]byte("+++ b/my file.go\t\n@@ -2,0 +3,2 @@\n+++ \"b/my \\\"file\\\".go\"\t\n@@ -1 +1 @@\n"))
+	a.NoError(err)
+	a.Equal(map[string][]parser.LineRange{
+		"my file.go":   {{Start: 3, End: 4}},
+		`my "file".go`: {{Start: 1, End: 1}},
+	}, changed)
+
+	_, err = parseDiffHunks([]byte("+++ b/a.go\n@@ -1 @@\n"))
+	a.Error(err)
+	_, err = parseDiffHunks([]byte("+++ b/a.go\n@@ -1 +x @@\n"))
+	a.Error(err)
+	_, err = parseDiffHunks([]byte("+++ b/a.go\n@@ -1 +1,x @@\n"))
+	a.Error(err)
+	_, err = parseDiffHunks([]byte("+++ \"b/a.go\n"))
+	a.Error(err)
+}
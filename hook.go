@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// hookScript is the pre-commit hook written by nofmt hook install
+const hookScript = `#!/bin/sh
+# installed by nofmt hook install
+exec nofmt hook run
+`
+
+// hookMain runs the hook install and hook run commands
+func hookMain(args []string) int {
+	if len(args) > 1 {
+		switch args[1] {
+		case "install":
+			return hookInstall(args[1:])
+		case "run":
+			return hookRun(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "usage: nofmt hook install [-f] [-restage] [-hooks-path <dir>] [-F <fmter>]")
+	fmt.Fprintln(os.Stderr, "       nofmt hook run [-restage] [-F <fmter>]")
+	return 2
+}
+
+// hookInstall writes the pre-commit hook into the repository
+func hookInstall(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	force := f.Bool("f", false, "replace an existing pre-commit hook")
+	restage := f.Bool("restage", false, "format and re-stage files instead of rejecting the commit")
+	hooksPath := f.String("hooks-path", "", "set core.hooksPath and install the hook there")
+	formatter := f.String("F", "", "formatter for the hook to use")
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+
+	err := installHook(*hooksPath, *force, *restage, *formatter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook install: %s\n", err)
+		return 2
+	}
+	return 0
+}
+
+func installHook(hooksPath string, force bool, restage bool, formatter string) error {
+	if len(hooksPath) > 0 {
+		_, err := git("config", "core.hooksPath", hooksPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// git resolves the hooks directory, including core.hooksPath
+	dir, err := git("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	hooks := strings.TrimSpace(string(dir))
+	err = os.MkdirAll(hooks, 0755)
+	if err != nil {
+		return err
+	}
+
+	hook := filepath.Join(hooks, "pre-commit")
+	old, err := ioutil.ReadFile(hook)
+	if err == nil && !force && !bytes.Equal(old, []byte(hookScript)) {
+		return fmt.Errorf("%s already exists, use -f to replace it", hook)
+	}
+	err = ioutil.WriteFile(hook, []byte(hookScript), 0755)
+	if err != nil {
+		return err
+	}
+	// WriteFile does not change the mode of an existing file
+	err = os.Chmod(hook, 0755)
+	if err != nil {
+		return err
+	}
+
+	if restage {
+		_, err = git("config", "--bool", "nofmt.restage", "true")
+		if err != nil {
+			return err
+		}
+	}
+	if len(formatter) > 0 {
+		_, err = git("config", "nofmt.formatter", formatter)
+	}
+	return err
+}
+
+// hookRun formats the staged Go files, as they are in the index.  Files
+// that are not formatted reject the commit, or are formatted and staged
+// again with -restage or git config nofmt.restage.
+func hookRun(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	restage := f.Bool("restage", false, "format and re-stage files instead of rejecting the commit")
+	formatter := f.String("F", "", "specify formatter 'program args' (default git config nofmt.formatter or \"gofmt %f\")")
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+
+	if !*restage {
+		out, _ := git("config", "--bool", "nofmt.restage")
+		*restage = strings.TrimSpace(string(out)) == "true"
+	}
+	if len(*formatter) == 0 {
+		out, _ := git("config", "nofmt.formatter")
+		*formatter = strings.TrimSpace(string(out))
+		if len(*formatter) == 0 {
+			*formatter = "gofmt %f"
+		}
+	}
+
+	unformatted, err := formatStaged(*formatter, *restage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nofmt: %s\n", err)
+		return 1
+	}
+	if len(unformatted) > 0 {
+		fmt.Fprintln(os.Stderr, "nofmt: staged files are not formatted:")
+		for _, file := range unformatted {
+			fmt.Fprintf(os.Stderr, "\t%s\n", file)
+		}
+		fmt.Fprintln(os.Stderr, "run nofmt -w on them and stage the changes, or commit with --no-verify")
+		return 1
+	}
+	return 0
+}
+
+// formatStaged formats the index contents of the staged Go files.  If
+// restage is set formatted contents are written back to the index, and to
+// the working tree when it matches the index, otherwise the files which
+// are not formatted are returned.
+func formatStaged(formatter string, restage bool) ([]string, error) {
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chdir(strings.TrimSpace(string(top)))
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := git("diff", "--cached", "--name-only", "--diff-filter=ACMR", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	fmter := parser.NewFormatter(formatter)
+	var unformatted []string
+	for _, file := range strings.Split(string(names), "\x00") {
+		if filepath.Ext(file) != ".go" {
+			continue
+		}
+
+		// <mode> SP <object> SP <stage> TAB <file>
+		entry, err := git("ls-files", "-s", "--", file)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(string(entry))
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s: not in the index", file)
+		}
+		mode, object := fields[0], fields[1]
+
+		src, err := git("cat-file", "blob", object)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := fmter.Format(src, file)
+		if err != nil {
+			return nil, err
+		}
+		if !res.Changed {
+			continue
+		}
+
+		if !restage {
+			unformatted = append(unformatted, file)
+			continue
+		}
+
+		err = restageFile(file, mode, src, res.Output)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return unformatted, nil
+}
+
+// restageFile writes formatted to the index for file.  The working tree
+// is only updated when it matches the index, so partially staged changes
+// are not lost.
+func restageFile(file string, mode string, src []byte, formatted []byte) error {
+	out, err := gitStdin(formatted, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	object := strings.TrimSpace(string(out))
+
+	_, err = git("update-index", "--cacheinfo", mode+","+object+","+file)
+	if err != nil {
+		return err
+	}
+
+	work, err := ioutil.ReadFile(file)
+	if err != nil || !bytes.Equal(work, src) {
+		return nil
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, formatted, st.Mode())
+}
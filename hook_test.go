@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	hookUnformatted = "package a\n\nvar a   int\n"
+	hookFormatted   = "package a\n\nvar a int\n"
+)
+
+// gitShow returns the index contents of file
+func gitShow(t *testing.T, file string) string {
+	out, err := exec.Command("git", "show", ":"+file).Output()
+	if err != nil {
+		t.Fatalf("git show %s: %s", file, err)
+	}
+	return string(out)
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHookInstall(t *testing.T) {
+	a := assert.New(t)
+	defer gitRepo(t)()
+
+	a.Equal(0, hookMain([]string{"hook", "install"}))
+	hook := filepath.Join(".git", "hooks", "pre-commit")
+	a.Equal(hookScript, readFile(t, hook))
+	st, err := os.Stat(hook)
+	a.NoError(err)
+	a.Equal(os.FileMode(0755), st.Mode().Perm())
+
+	// installing again is fine, replacing another hook is not
+	a.Equal(0, hookMain([]string{"hook", "install"}))
+	writeFile(t, hook, "#!/bin/sh\nexit 0\n")
+	a.Equal(2, hookMain([]string{"hook", "install"}))
+	a.Equal(0, hookMain([]string{"hook", "install", "-f", "-restage", "-F", "gofmt -s %f"}))
+	a.Equal(hookScript, readFile(t, hook))
+
+	out, err := git("config", "nofmt.restage")
+	a.NoError(err)
+	a.Equal("true", strings.TrimSpace(string(out)))
+	out, err = git("config", "nofmt.formatter")
+	a.NoError(err)
+	a.Equal("gofmt -s %f", strings.TrimSpace(string(out)))
+
+	a.Equal(0, hookMain([]string{"hook", "install", "-hooks-path", "hooks"}))
+	a.Equal(hookScript, readFile(t, filepath.Join("hooks", "pre-commit")))
+
+	a.Equal(2, hookMain([]string{"hook"}))
+	a.Equal(2, hookMain([]string{"hook", "bogus"}))
+
+	flagErrorHandling = flag.ContinueOnError
+	defer func() { flagErrorHandling = flag.ExitOnError }()
+	a.Equal(2, hookMain([]string{"hook", "install", "-bogus"}))
+}
+
+func TestHookRun(t *testing.T) {
+	a := assert.New(t)
+	defer gitRepo(t)()
+
+	writeFile(t, "a.go", hookUnformatted)
+	writeFile(t, "sub/b.go", hookUnformatted)
+	writeFile(t, "c.go", hookFormatted)
+	writeFile(t, "notes.txt", "notes   \n")
+	gitRun(t, "add", ".")
+
+	// rejected, nothing changes
+	a.Equal(1, hookMain([]string{"hook", "run"}))
+	a.Equal(hookUnformatted, gitShow(t, "a.go"))
+	a.Equal(hookUnformatted, readFile(t, "a.go"))
+
+	// b.go has unstaged changes which must be kept
+	writeFile(t, "sub/b.go", hookUnformatted+"var b   int\n")
+
+	os.Chdir("sub")
+	a.Equal(0, hookMain([]string{"hook", "run", "-restage"}))
+	a.Equal(hookFormatted, gitShow(t, "a.go"))
+	a.Equal(hookFormatted, readFile(t, "a.go"))
+	a.Equal(hookFormatted, gitShow(t, "sub/b.go"))
+	a.Equal(hookUnformatted+"var b   int\n", readFile(t, filepath.Join("sub", "b.go")))
+	a.Equal(hookFormatted, gitShow(t, "c.go"))
+	a.Equal("notes   \n", gitShow(t, "notes.txt"))
+
+	a.Equal(0, hookMain([]string{"hook", "run"}))
+
+	// restage from git config
+	writeFile(t, "a.go", hookUnformatted)
+	gitRun(t, "add", "a.go")
+	gitRun(t, "config", "nofmt.restage", "true")
+	a.Equal(0, hookMain([]string{"hook", "run"}))
+	a.Equal(hookFormatted, gitShow(t, "a.go"))
+
+	// syntax errors reject the commit
+	writeFile(t, "a.go", "package a\n\nvar a   int =\n")
+	gitRun(t, "add", "a.go")
+	a.Equal(1, hookMain([]string{"hook", "run"}))
+}
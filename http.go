@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// maxHTTPSource is the largest source nofmt http formats
+const maxHTTPSource = 1 << 20
+
+// httpRequest is the body of a POST to /format
+type httpRequest struct {
+	Content string `json:"content"`
+}
+
+// httpResponse is the answer to a httpRequest
+type httpResponse struct {
+	Output  string               `json:"output"`
+	Changed bool                 `json:"changed"`
+	Regions []parser.Region      `json:"regions,omitempty"` // regions of the content
+	Error   string               `json:"error,omitempty"`
+	Errors  []parser.SourceError `json:"errors,omitempty"` // errors in the content, if Error is a syntax error
+}
+
+// httpMain serves the format API and playground page over HTTP
+func httpMain(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	addr := f.String("addr", "localhost:8080", "address to listen on")
+	formatter := f.String("F", "gofmt %f", "specify formatter 'program args' (filename will be appended unless %f is used)")
+	timeout := f.Duration("timeout", 10*time.Second, "stop the formatter if a request takes longer than this")
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nofmt http [-addr <host:port>] [-F <fmter>] [-timeout <duration>]\n")
+		f.PrintDefaults()
+	}
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+
+	command, err := parser.ParseCommand(*formatter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "http: bad formatter: %s\n", err)
+		return 2
+	}
+
+	err = http.ListenAndServe(*addr, newHTTPHandler(parser.NewFormatterArgs(command), *timeout))
+	fmt.Fprintf(os.Stderr, "http: %s\n", err)
+	return 2
+}
+
+// newHTTPHandler returns the handler of nofmt http, formatting with
+// fmter.  The formatter is only chosen by the server, as anyone who can
+// choose it can run any program.
+func newHTTPHandler(fmter *parser.Formatter, timeout time.Duration) http.Handler {
+	s := &httpServer{fmter: fmter, timeout: timeout}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.page)
+	mux.HandleFunc("/format", s.format)
+	return mux
+}
+
+// httpServer answers the requests of nofmt http
+type httpServer struct {
+	fmter   *parser.Formatter
+	timeout time.Duration
+}
+
+// page serves the playground
+func (s *httpServer) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, playgroundPage)
+}
+
+// format formats the content of a httpRequest
+func (s *httpServer) format(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, httpResponse{Error: "method not allowed"})
+		return
+	}
+
+	var req httpRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHTTPSource)).Decode(&req)
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, httpResponse{Error: "source is too large"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, httpResponse{Error: "bad request: " + err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	res, err := s.fmter.FormatContext(ctx, []byte(req.Content), "")
+	if err != nil {
+		resp := httpResponse{Error: err.Error()}
+		var synErr *parser.SyntaxError
+		var mismatch *parser.BlockMismatchError
+		switch {
+		case errors.As(err, &synErr):
+			resp.Errors = synErr.Errors
+			writeJSON(w, http.StatusUnprocessableEntity, resp)
+		case errors.As(err, &mismatch):
+			writeJSON(w, http.StatusUnprocessableEntity, resp)
+		default:
+			writeJSON(w, http.StatusInternalServerError, resp)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, httpResponse{
+		Output:  string(res.Output),
+		Changed: res.Changed,
+		Regions: res.Regions,
+	})
+}
+
+// writeJSON writes v as the body of the response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// playgroundPage formats the source pasted into it with /format, showing
+// the output and the regions of the source left alone
+const playgroundPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nofmt</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+textarea, pre { font-family: monospace; font-size: 13px; width: 100%; box-sizing: border-box; }
+textarea { height: 20em; }
+pre { border: 1px solid #ccc; padding: 4px; min-height: 2em; white-space: pre; overflow: auto; }
+.nofmt { background: #fff3c4; }
+.error { color: #b00; }
+.panes { display: flex; gap: 1em; }
+.panes > div { flex: 1; min-width: 0; }
+</style>
+</head>
+<body>
+<h1>nofmt</h1>
+<p>Paste Go code and format it.  Lines between <code>// go:nofmt</code> and
+<code>// go:fmt</code> are <span class="nofmt">left alone</span>.</p>
+<textarea id="src" spellcheck="false">package main
+
+import "fmt"
+
+func main() {
+	// go:nofmt
+	var s                string
+	var longVariableName string
+	// go:fmt
+
+	fmt.Println(s,    longVariableName)
+}
+</textarea>
+<p><button id="format">Format</button> <span id="status"></span></p>
+<div class="panes">
+<div><h2>Source</h2><pre id="regions"></pre></div>
+<div><h2>Output</h2><pre id="output"></pre></div>
+</div>
+<script>
+function show(src, regions) {
+	var lines = src.split("\n");
+	var pre = document.getElementById("regions");
+	pre.textContent = "";
+	(regions || []).forEach(function (r) {
+		var span = document.createElement("span");
+		if (!r.formatted) {
+			span.className = "nofmt";
+		}
+		span.textContent = lines.slice(r.start - 1, r.end).join("\n") + "\n";
+		pre.appendChild(span);
+	});
+}
+
+document.getElementById("format").onclick = function () {
+	var src = document.getElementById("src").value;
+	var status = document.getElementById("status");
+	var output = document.getElementById("output");
+	status.textContent = "formatting...";
+	status.className = "";
+	fetch("format", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({content: src})
+	}).then(function (resp) {
+		return resp.json();
+	}).then(function (res) {
+		if (res.error) {
+			status.textContent = res.error;
+			status.className = "error";
+			output.textContent = (res.errors || []).map(function (e) {
+				return e.line + ":" + e.column + ": " + e.message;
+			}).join("\n");
+			show(src, [{start: 1, end: src.split("\n").length, formatted: true}]);
+			return;
+		}
+		status.textContent = res.changed ? "formatted" : "already formatted";
+		output.textContent = res.output;
+		show(src, res.regions);
+	}).catch(function (err) {
+		status.textContent = err;
+		status.className = "error";
+	});
+};
+</script>
+</body>
+</html>
+`
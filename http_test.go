@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debspencer/nofmt/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	s := httptest.NewServer(newHTTPHandler(parser.New(), 5*time.Second))
+	defer s.Close()
+
+	post := func(body string) (int, httpResponse) {
+		resp, err := http.Post(s.URL+"/format", "application/json", strings.NewReader(body))
+		a.NoError(err)
+		defer resp.Body.Close()
+		a.Equal("application/json", resp.Header.Get("Content-Type"))
+		var res httpResponse
+		a.NoError(json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	src := "package main\n\n// go:nofmt\nvar a   int\n// go:fmt\nvar b   int\n"
+	body, _ := json.Marshal(httpRequest{Content: src})
+	status, res := post(string(body))
+	a.Equal(http.StatusOK, status)
+	a.Equal(httpResponse{
+		Output:  "package main\n\n// go:nofmt\nvar a   int\n// go:fmt\nvar b int\n",
+		Changed: true,
+		Regions: []parser.Region{
+			{LineRange: parser.LineRange{Start: 1, End: 3}, Formatted: true},
+			{LineRange: parser.LineRange{Start: 4, End: 4}},
+			{LineRange: parser.LineRange{Start: 5, End: 6}, Formatted: true},
+		},
+	}, res)
+
+	status, res = post(`{"content": "package main\n\nvar b = \n"}`)
+	a.Equal(http.StatusUnprocessableEntity, status)
+	a.Equal([]parser.SourceError{{File: "<stdin>", Line: 3, Column: 10, Message: "expected operand, found 'EOF'"}}, res.Errors)
+
+	status, res = post(`{"content": `)
+	a.Equal(http.StatusBadRequest, status)
+	a.Contains(res.Error, "bad request")
+
+	status, res = post(`{"content": "` + strings.Repeat("a", maxHTTPSource) + `"}`)
+	a.Equal(http.StatusRequestEntityTooLarge, status)
+
+	resp, err := http.Get(s.URL + "/format")
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Get(s.URL + "/")
+	a.NoError(err)
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Contains(resp.Header.Get("Content-Type"), "text/html")
+	a.Contains(string(page), "<textarea")
+
+	resp, err = http.Get(s.URL + "/no/such/page")
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	flagErrorHandling = flag.ContinueOnError
+	a.Equal(2, httpMain([]string{"http", "-no-such-flag"}))
+	a.Equal(2, httpMain([]string{"http", "-F", `"gofmt`}))
+	a.Equal(2, httpMain([]string{"http", "-addr", "no such address"}))
+}
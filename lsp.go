@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/debspencer/nofmt/lsp"
+	"github.com/debspencer/nofmt/parser"
+)
+
+// lspMain runs nofmt as a formatting language server on stdin and stdout
+func lspMain(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	formatter := f.String("F", "gofmt %f", "specify formatter 'program args' (filename will be appended unless %f is used)")
+	proxy := f.String("proxy", "", "forward to language server 'program args', fixing its formatting")
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nofmt lsp [-F <fmter>] [-proxy <server>]\n")
+		f.PrintDefaults()
+	}
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+
+	var err error
+	if len(*proxy) > 0 {
+		err = lspProxy(*proxy)
+	} else {
+		s := lsp.NewServer(*formatter)
+		err = s.Serve(os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %s\n", err)
+		return 2
+	}
+	return 0
+}
+
+// lspProxy starts the language server, a command line split as by
+// parser.ParseCommand, and proxies stdin and stdout to it
+func lspProxy(server string) error {
+	args, err := parser.ParseCommand(server)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no language server given")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = lsp.NewProxy().Serve(os.Stdin, os.Stdout, out, in)
+	waitErr := cmd.Wait()
+	if err == nil {
+		err = waitErr
+	}
+	return err
+}
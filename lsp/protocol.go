@@ -0,0 +1,262 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// JSON-RPC error codes used by the language server protocol
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeRequestFailed  = -32803
+)
+
+// message is a JSON-RPC 2.0 request, notification or response.
+// Requests have an ID and a Method, notifications only a Method and
+// responses only an ID.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *responseError  `json:"error,omitempty"`
+}
+
+func (m *message) isRequest() bool { return len(m.ID) > 0 && len(m.Method) > 0 }
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// conn reads and writes messages framed with a Content-Length header
+type conn struct {
+	in  *bufio.Reader
+	out io.Writer
+	mu  sync.Mutex // serializes writes to out
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// readRaw will read the body of the next message
+func (c *conn) readRaw() ([]byte, error) {
+	length := -1
+	for {
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		name := strings.SplitN(line, ":", 2)
+		if len(name) == 2 && strings.EqualFold(strings.TrimSpace(name[0]), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(name[1]))
+			if err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %s", name[1])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(c.in, body)
+	return body, err
+}
+
+// read will read and decode the next message
+func (c *conn) read() (*message, error) {
+	body, err := c.readRaw()
+	if err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	err = json.Unmarshal(body, msg)
+	return msg, err
+}
+
+// writeRaw will write a message body with its header
+func (c *conn) writeRaw(body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.writeRaw(body)
+}
+
+func (c *conn) reply(id json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id}
+	if err != nil {
+		rerr, ok := err.(*responseError)
+		if !ok {
+			rerr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		msg.Error = rerr
+		return c.write(msg)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	msg.Result = data
+	return c.write(msg)
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: data})
+}
+
+// Position is a zero based line and UTF-16 character offset
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span of text, the End is exclusive
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// TextEdit replaces Range with NewText
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// Diagnostic is a problem reported to the editor
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+const severityWarning = 2
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type contentChange struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type didChangeParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Changes      []contentChange        `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type formattingParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type rangeFormattingParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// uriToPath converts a file:// URI to a local path
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// textEdits converts the line edits of parser.Edits into LSP edits on src
+func textEdits(src string, edits []parser.Edit) []TextEdit {
+	lines := strings.SplitAfter(src, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	tedits := make([]TextEdit, 0, len(edits))
+	for _, e := range edits {
+		end := Position{Line: e.End}
+		if e.End == len(lines) && e.End > 0 && !strings.HasSuffix(src, "\n") {
+			// the last line has no newline, so end at the end of it
+			end = Position{Line: e.End - 1, Character: utf16Len(lines[e.End-1])}
+		}
+		tedits = append(tedits, TextEdit{
+			Range: Range{
+				Start: Position{Line: e.Start},
+				End:   end,
+			},
+			NewText: strings.Join(e.Lines, ""),
+		})
+	}
+	return tedits
+}
+
+// utf16Len returns the number of UTF-16 code units in s
+func utf16Len(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r >= 0x10000 {
+			n++
+		}
+		n++
+		s = s[size:]
+	}
+	return n
+}
@@ -0,0 +1,378 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// Proxy sits between an editor and another language server, normally
+// gopls.  All messages are forwarded untouched, except the results of
+// formatting requests and organize imports code actions, which are
+// merged with the document so changes inside of go:nofmt blocks are
+// dropped.
+type Proxy struct {
+	mu      sync.Mutex
+	docs    map[string]string  // text of open documents by URI
+	pending map[string]pending // editor requests waiting on a result, by ID
+}
+
+// pending is a request from the editor that will need its result fixed
+type pending struct {
+	method string
+	uri    string
+}
+
+// NewProxy returns a Proxy
+func NewProxy() *Proxy {
+	return &Proxy{
+		docs:    make(map[string]string),
+		pending: make(map[string]pending),
+	}
+}
+
+// Serve will forward messages from the editor to the server and from the
+// server to the editor.  When the editor closes its side serverOut is
+// closed.  Serve returns once the server has closed its output.
+func (p *Proxy) Serve(editorIn io.Reader, editorOut io.Writer, serverIn io.Reader, serverOut io.WriteCloser) error {
+	editor := newConn(editorIn, editorOut)
+	server := newConn(serverIn, serverOut)
+
+	errChan := make(chan error, 1)
+	go func() {
+		err := p.forward(editor, server, p.fromEditor)
+		serverOut.Close()
+		errChan <- err
+	}()
+
+	err := p.forward(server, editor, p.fromServer)
+	if err != nil {
+		return err
+	}
+
+	// the server is gone, so errors writing to it no longer matter, but
+	// report any problem reading from the editor
+	select {
+	case err = <-errChan:
+		if _, ok := err.(*writeError); ok {
+			err = nil
+		}
+	default:
+	}
+	return err
+}
+
+// writeError is an error writing to the other side of a forward
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+// forward copies messages from one connection to the other until the
+// reader is closed.  fix may return a replacement for the message body.
+func (p *Proxy) forward(from *conn, to *conn, fix func(msg *message) []byte) error {
+	for {
+		body, err := from.readRaw()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		msg := &message{}
+		if json.Unmarshal(body, msg) == nil {
+			if fixed := fix(msg); fixed != nil {
+				body = fixed
+			}
+		}
+
+		err = to.writeRaw(body)
+		if err != nil {
+			return &writeError{err}
+		}
+	}
+}
+
+// fromEditor tracks the documents and formatting requests of the editor,
+// messages to the server are never changed
+func (p *Proxy) fromEditor(msg *message) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			p.docs[params.TextDocument.URI] = params.TextDocument.Text
+		}
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			uri := params.TextDocument.URI
+			text := p.docs[uri]
+			for _, change := range params.Changes {
+				if change.Range == nil {
+					text = change.Text
+					continue
+				}
+				var err error
+				text, err = applyTextEdits(text, []TextEdit{{Range: *change.Range, NewText: change.Text}})
+				if err != nil {
+					// lost track of the document, it will be read from disk
+					delete(p.docs, uri)
+					return nil
+				}
+			}
+			p.docs[uri] = text
+		}
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			delete(p.docs, params.TextDocument.URI)
+		}
+
+	case "textDocument/formatting", "textDocument/rangeFormatting", "textDocument/codeAction":
+		var params formattingParams
+		if msg.isRequest() && json.Unmarshal(msg.Params, &params) == nil {
+			p.pending[string(msg.ID)] = pending{method: msg.Method, uri: params.TextDocument.URI}
+		}
+
+	case "codeAction/resolve":
+		if msg.isRequest() {
+			p.pending[string(msg.ID)] = pending{method: msg.Method}
+		}
+	}
+	return nil
+}
+
+// fromServer will fix the results of formatting requests
+func (p *Proxy) fromServer(msg *message) []byte {
+	if len(msg.Method) > 0 || len(msg.ID) == 0 {
+		return nil // requests and notifications from the server
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req, ok := p.pending[string(msg.ID)]
+	if !ok {
+		return nil
+	}
+	delete(p.pending, string(msg.ID))
+	if msg.Error != nil || len(msg.Result) == 0 || string(msg.Result) == "null" {
+		return nil
+	}
+
+	var result interface{}
+	var err error
+	switch req.method {
+	case "textDocument/formatting", "textDocument/rangeFormatting":
+		var edits []TextEdit
+		err = json.Unmarshal(msg.Result, &edits)
+		if err == nil {
+			result, err = p.fixEdits(req.uri, edits)
+		}
+	case "textDocument/codeAction":
+		var actions []json.RawMessage
+		err = json.Unmarshal(msg.Result, &actions)
+		if err == nil {
+			for i := range actions {
+				actions[i], err = p.fixAction(actions[i])
+				if err != nil {
+					break
+				}
+			}
+			result = actions
+		}
+	case "codeAction/resolve":
+		result, err = p.fixAction(msg.Result)
+	}
+
+	reply := &message{JSONRPC: "2.0", ID: msg.ID}
+	if err != nil {
+		reply.Error = &responseError{Code: codeRequestFailed, Message: "nofmt: " + err.Error()}
+	} else {
+		reply.Result, err = json.Marshal(result)
+		if err != nil {
+			return nil
+		}
+	}
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// fixAction will fix the edits of an organize imports code action.
+// Commands and other actions are returned untouched.
+func (p *Proxy) fixAction(raw json.RawMessage) (json.RawMessage, error) {
+	var action map[string]json.RawMessage
+	if json.Unmarshal(raw, &action) != nil {
+		return raw, nil
+	}
+	var kind string
+	json.Unmarshal(action["kind"], &kind)
+	if kind != "source.organizeImports" && !strings.HasPrefix(kind, "source.organizeImports.") {
+		return raw, nil
+	}
+	if len(action["edit"]) == 0 {
+		return raw, nil
+	}
+
+	var edit map[string]json.RawMessage
+	err := json.Unmarshal(action["edit"], &edit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(edit["changes"]) > 0 {
+		var changes map[string][]TextEdit
+		err = json.Unmarshal(edit["changes"], &changes)
+		if err != nil {
+			return nil, err
+		}
+		for uri, edits := range changes {
+			changes[uri], err = p.fixEdits(uri, edits)
+			if err != nil {
+				return nil, err
+			}
+		}
+		edit["changes"], err = json.Marshal(changes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(edit["documentChanges"]) > 0 {
+		var docChanges []map[string]json.RawMessage
+		err = json.Unmarshal(edit["documentChanges"], &docChanges)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range docChanges {
+			if len(change["edits"]) == 0 {
+				continue // create, rename or delete of a file
+			}
+			var doc textDocumentIdentifier
+			var edits []TextEdit
+			err = json.Unmarshal(change["textDocument"], &doc)
+			if err == nil {
+				err = json.Unmarshal(change["edits"], &edits)
+			}
+			if err == nil {
+				edits, err = p.fixEdits(doc.URI, edits)
+			}
+			if err == nil {
+				change["edits"], err = json.Marshal(edits)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		edit["documentChanges"], err = json.Marshal(docChanges)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	action["edit"], err = json.Marshal(edit)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(action)
+}
+
+// fixEdits applies edits to the document and merges the result with the
+// document, returning the edits that format everything but the go:nofmt
+// blocks
+func (p *Proxy) fixEdits(uri string, edits []TextEdit) ([]TextEdit, error) {
+	text, err := documentText(p.docs, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	formatted, err := applyTextEdits(text, edits)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := parser.Merge([]byte(text), []byte(formatted))
+	if err != nil {
+		return nil, err
+	}
+	return textEdits(text, parser.Edits([]byte(text), merged)), nil
+}
+
+// applyTextEdits returns text with the edits applied.  As in the
+// protocol, all edits refer to the original text.
+func applyTextEdits(text string, edits []TextEdit) (string, error) {
+	type span struct {
+		start, end int
+		text       string
+	}
+	spans := make([]span, 0, len(edits))
+	for _, e := range edits {
+		start, err := offset(text, e.Range.Start)
+		if err != nil {
+			return "", err
+		}
+		end, err := offset(text, e.Range.End)
+		if err != nil {
+			return "", err
+		}
+		if end < start {
+			return "", fmt.Errorf("edit ends before it starts: %v", e.Range)
+		}
+		spans = append(spans, span{start, end, e.NewText})
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	n := 0
+	for _, s := range spans {
+		if s.start < n {
+			return "", fmt.Errorf("overlapping edits")
+		}
+		b.WriteString(text[n:s.start])
+		b.WriteString(s.text)
+		n = s.end
+	}
+	b.WriteString(text[n:])
+	return b.String(), nil
+}
+
+// offset converts a position into a byte offset of text.  Positions past
+// the end of a line are the end of the line, past the end of the text are
+// the end of the text.
+func offset(text string, pos Position) (int, error) {
+	if pos.Line < 0 || pos.Character < 0 {
+		return 0, fmt.Errorf("invalid position %d:%d", pos.Line, pos.Character)
+	}
+	n := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[n:], '\n')
+		if i < 0 {
+			return len(text), nil
+		}
+		n += i + 1
+	}
+
+	for chars := 0; chars < pos.Character && n < len(text) && text[n] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		chars += utf16Len(string(r))
+		n += size
+	}
+	return n, nil
+}
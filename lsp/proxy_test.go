@@ -0,0 +1,156 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer answers formatting requests by replacing the document with
+// formatted, as a formatter that does not know about go:nofmt would.
+func fakeServer(in io.Reader, out io.WriteCloser, formatted string) {
+	defer out.Close()
+
+	c := newConn(in, out)
+	whole := Range{Start: Position{Line: 0}, End: Position{Line: 100}}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return
+		}
+		switch msg.Method {
+		case "textDocument/formatting":
+			c.notify("window/logMessage", map[string]interface{}{"type": 3, "message": "formatting"})
+			c.reply(msg.ID, []TextEdit{{Range: whole, NewText: formatted}}, nil)
+		case "textDocument/codeAction":
+			c.reply(msg.ID, []interface{}{
+				map[string]interface{}{
+					"title": "Organize Imports",
+					"kind":  "source.organizeImports",
+					"edit": map[string]interface{}{
+						"documentChanges": []interface{}{
+							map[string]interface{}{
+								"textDocument": map[string]interface{}{"uri": testURI, "version": 2},
+								"edits":        []TextEdit{{Range: whole, NewText: formatted}},
+							},
+						},
+					},
+				},
+				map[string]interface{}{"title": "Run", "command": "gopls.run"},
+			}, nil)
+		case "shutdown":
+			c.reply(msg.ID, nil, nil)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	a := assert.New(t)
+
+	src := "package main\n\nfunc main() {\n// go:nofmt\nvar a   int\n// go:fmt\nvar b   int\n}\n"
+	formatted := "package main\n\nfunc main() {\n\t// go:nofmt\n\tvar a int\n\t// go:fmt\n\tvar bb int\n}\n"
+	expected := "package main\n\nfunc main() {\n\t// go:nofmt\nvar a   int\n\t// go:fmt\n\tvar bb int\n}\n"
+
+	editorIn, editor := io.Pipe()
+	fromProxy, editorOut := io.Pipe()
+	serverIn, toServer := io.Pipe()
+	fromServer, serverOut := io.Pipe()
+
+	go fakeServer(serverIn, serverOut, formatted)
+
+	done := make(chan error)
+	go func() {
+		done <- NewProxy().Serve(editorIn, editorOut, fromServer, toServer)
+		editorOut.Close()
+	}()
+
+	send := newConn(nil, editor)
+	recv := newConn(fromProxy, nil)
+	doc := map[string]string{"uri": testURI}
+
+	send.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "version": 1, "text": src},
+	})
+	send.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "version": 2},
+		"contentChanges": []contentChange{
+			{Range: &Range{Start: Position{Line: 6, Character: 4}, End: Position{Line: 6, Character: 5}}, Text: "bb"},
+		},
+	})
+
+	// formatting results are merged
+	send.write(&message{ID: json.RawMessage(`1`), Method: "textDocument/formatting", Params: mustMarshal(map[string]interface{}{"textDocument": doc})})
+
+	msg, err := recv.read()
+	a.NoError(err)
+	a.Equal("window/logMessage", msg.Method)
+
+	msg, err = recv.read()
+	a.NoError(err)
+	a.Equal(`1`, string(msg.ID))
+	var edits []TextEdit
+	a.NoError(json.Unmarshal(msg.Result, &edits))
+	a.Len(edits, 2)
+	text, err := applyTextEdits("package main\n\nfunc main() {\n// go:nofmt\nvar a   int\n// go:fmt\nvar bb   int\n}\n", edits)
+	a.NoError(err)
+	a.Equal(expected, text)
+
+	// organize imports edits are merged, commands are untouched
+	send.write(&message{ID: json.RawMessage(`"two"`), Method: "textDocument/codeAction", Params: mustMarshal(map[string]interface{}{"textDocument": doc})})
+
+	msg, err = recv.read()
+	a.NoError(err)
+	a.Equal(`"two"`, string(msg.ID))
+	var actions []struct {
+		Command string `json:"command"`
+		Edit    struct {
+			DocumentChanges []struct {
+				Edits []TextEdit `json:"edits"`
+			} `json:"documentChanges"`
+		} `json:"edit"`
+	}
+	a.NoError(json.Unmarshal(msg.Result, &actions))
+	a.Len(actions, 2)
+	a.Equal("gopls.run", actions[1].Command)
+	text, err = applyTextEdits("package main\n\nfunc main() {\n// go:nofmt\nvar a   int\n// go:fmt\nvar bb   int\n}\n", actions[0].Edit.DocumentChanges[0].Edits)
+	a.NoError(err)
+	a.Equal(expected, text)
+
+	// other requests are passed through
+	send.write(&message{ID: json.RawMessage(`3`), Method: "shutdown"})
+	msg, err = recv.read()
+	a.NoError(err)
+	a.Equal(`3`, string(msg.ID))
+	a.Equal(`null`, string(msg.Result))
+
+	editor.Close()
+	a.NoError(<-done)
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	a := assert.New(t)
+
+	text, err := applyTextEdits("aé😀b\nc", []TextEdit{
+		{Range: Range{Start: Position{Line: 0, Character: 4}, End: Position{Line: 0, Character: 5}}, NewText: "B"},
+		{Range: Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 9, Character: 0}}, NewText: "C\n"},
+		{Range: Range{Start: Position{Line: 0, Character: 0}, End: Position{Line: 0, Character: 0}}, NewText: ">"},
+	})
+	a.NoError(err)
+	a.Equal(">aé😀B\nC\n", text)
+
+	_, err = applyTextEdits("abc", []TextEdit{
+		{Range: Range{Start: Position{Line: 0, Character: 0}, End: Position{Line: 0, Character: 2}}},
+		{Range: Range{Start: Position{Line: 0, Character: 1}, End: Position{Line: 0, Character: 3}}},
+	})
+	a.Error(err)
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,210 @@
+// Package lsp implements a formatting only language server which
+// formats Go source with nofmt, honoring the // go:nofmt and // go:fmt
+// pragmas.
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// Server is a language server that provides document formatting and
+// diagnostics for unbalanced pragmas
+type Server struct {
+	formatter string            // formatter with arguments, see parser.NewFormatter
+	conn      *conn             // connection to the editor
+	docs      map[string]string // text of open documents by URI
+	shutdown  bool              // shutdown request has been received
+}
+
+// NewServer returns a Server that formats using formatter.
+// See parser.NewFormatter for the format of formatter.
+func NewServer(formatter string) *Server {
+	return &Server{
+		formatter: formatter,
+		docs:      make(map[string]string),
+	}
+}
+
+// Serve will answer requests read from in, writing responses to out,
+// until the exit notification is received or in is closed.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.conn = newConn(in, out)
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if _, ok := err.(*json.SyntaxError); ok {
+				s.conn.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})
+				continue
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if msg.isRequest() {
+			err = s.conn.reply(msg.ID, result, err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle will process a single request or notification
+func (s *Server) handle(msg *message) (interface{}, error) {
+	if s.shutdown && msg.isRequest() {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":                1, // full document sync
+				"documentFormattingProvider":      true,
+				"documentRangeFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{
+				"name": "nofmt",
+			},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.docs[params.TextDocument.URI] = params.TextDocument.Text
+		return nil, s.diagnose(params.TextDocument.URI)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		// only full document sync is offered, so the last change is the document
+		if len(params.Changes) > 0 {
+			s.docs[params.TextDocument.URI] = params.Changes[len(params.Changes)-1].Text
+		}
+		return nil, s.diagnose(params.TextDocument.URI)
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		// clear any diagnostics for the closed document
+		return nil, s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+
+	case "textDocument/formatting":
+		var params formattingParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.format(params.TextDocument.URI, nil)
+
+	case "textDocument/rangeFormatting":
+		var params rangeFormattingParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.format(params.TextDocument.URI, &params.Range)
+	}
+
+	if msg.isRequest() {
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method not supported: " + msg.Method}
+	}
+	// unknown notifications are ignored
+	return nil, nil
+}
+
+func invalidParams(err error) error {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+// documentText returns the text of a document, from the editor if it is
+// open, otherwise from disk
+func documentText(docs map[string]string, uri string) (string, error) {
+	text, ok := docs[uri]
+	if ok {
+		return text, nil
+	}
+	data, err := ioutil.ReadFile(uriToPath(uri))
+	return string(data), err
+}
+
+// format returns the edits needed to format the document.  If rng is not
+// nil only the lines of rng are formatted.
+func (s *Server) format(uri string, rng *Range) ([]TextEdit, error) {
+	text, err := documentText(s.docs, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	fmter := parser.NewFormatter(s.formatter)
+	fmter.Filename = uriToPath(uri)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if rng != nil {
+		last := rng.End.Line
+		if rng.End.Character == 0 && last > rng.Start.Line {
+			// the range ends at the start of the line, so the line is not included
+			last--
+		}
+		lines := []parser.LineRange{{Start: rng.Start.Line + 1, End: last + 1}}
+		err = fmter.FormatLines(strings.NewReader(text), stdout, stderr, lines)
+	} else {
+		err = fmter.FormatReader(strings.NewReader(text), stdout, stderr)
+	}
+	if err != nil {
+		if stderr.Len() > 0 {
+			return nil, &responseError{Code: codeRequestFailed, Message: strings.TrimSpace(stderr.String())}
+		}
+		return nil, err
+	}
+
+	return textEdits(text, parser.Edits([]byte(text), stdout.Bytes())), nil
+}
+
+// diagnose publishes the unbalanced pragmas of a document
+func (s *Server) diagnose(uri string) error {
+	text := s.docs[uri]
+	lines := strings.SplitAfter(text, "\n")
+
+	diags := []Diagnostic{}
+	for _, p := range parser.CheckPragmas([]byte(text)) {
+		line := strings.TrimRight(lines[p.Line-1], "\r\n")
+		diags = append(diags, Diagnostic{
+			Range: Range{
+				Start: Position{Line: p.Line - 1, Character: utf16Len(line) - utf16Len(strings.TrimLeft(line, " \t"))},
+				End:   Position{Line: p.Line - 1, Character: utf16Len(line)},
+			},
+			Severity: severityWarning,
+			Source:   "nofmt",
+			Message:  p.Message,
+		})
+	}
+	return s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diags,
+	})
+}
@@ -0,0 +1,172 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/debspencer/nofmt/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testURI = "file:///tmp/test.go"
+
+	testSrc = "package main\n" +
+		"\n" +
+		"func main() {\n" +
+		"        var s                string\n" +
+		"        // go:nofmt\n" +
+		"        var longVariableName string\n" +
+		"        // go:fmt\n" +
+		"        println(s, longVariableName)\n" +
+		"}\n"
+)
+
+// session builds the input for a server from a list of messages
+func session(msgs ...string) *bytes.Buffer {
+	in := &bytes.Buffer{}
+	for _, m := range msgs {
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	return in
+}
+
+// responses reads all of the messages written by a server
+func responses(t *testing.T, out *bytes.Buffer) []*message {
+	c := newConn(out, nil)
+	var msgs []*message
+	for {
+		msg, err := c.read()
+		if err == io.EOF {
+			return msgs
+		}
+		assert.NoError(t, err)
+		msgs = append(msgs, msg)
+	}
+}
+
+func request(id int, method string, params interface{}) string {
+	data, _ := json.Marshal(params)
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, id, method, data)
+}
+
+func notification(method string, params interface{}) string {
+	data, _ := json.Marshal(params)
+	return fmt.Sprintf(`{"jsonrpc":"2.0","method":%q,"params":%s}`, method, data)
+}
+
+func TestServer(t *testing.T) {
+	a := assert.New(t)
+
+	doc := map[string]interface{}{"textDocument": map[string]string{"uri": testURI}}
+	in := session(
+		request(1, "initialize", map[string]interface{}{}),
+		notification("initialized", map[string]interface{}{}),
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": testURI, "languageId": "go", "version": 1, "text": testSrc + "// go:fmt\n"},
+		}),
+		notification("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": testURI, "version": 2},
+			"contentChanges": []map[string]string{{"text": testSrc}},
+		}),
+		request(2, "textDocument/formatting", doc),
+		request(3, "textDocument/rangeFormatting", map[string]interface{}{
+			"textDocument": map[string]string{"uri": testURI},
+			"range":        Range{Start: Position{Line: 7}, End: Position{Line: 8}},
+		}),
+		request(4, "textDocument/hover", doc),
+		request(5, "shutdown", nil),
+		request(6, "textDocument/formatting", doc),
+		notification("exit", nil),
+		request(7, "shutdown", nil), // never read
+	)
+	out := &bytes.Buffer{}
+
+	s := NewServer("gofmt")
+	a.NoError(s.Serve(in, out))
+
+	msgs := responses(t, out)
+	a.Len(msgs, 8)
+
+	// initialize
+	a.Equal(`1`, string(msgs[0].ID))
+	a.Contains(string(msgs[0].Result), `"documentFormattingProvider":true`)
+
+	// didOpen, the extra go:fmt is reported
+	var diags publishDiagnosticsParams
+	a.Equal("textDocument/publishDiagnostics", msgs[1].Method)
+	a.NoError(json.Unmarshal(msgs[1].Params, &diags))
+	a.Equal(testURI, diags.URI)
+	a.Equal([]Diagnostic{{
+		Range:    Range{Start: Position{Line: 9, Character: 0}, End: Position{Line: 9, Character: 9}},
+		Severity: severityWarning,
+		Source:   "nofmt",
+		Message:  "go:fmt without a matching go:nofmt",
+	}}, diags.Diagnostics)
+
+	// didChange, the problem is fixed
+	a.NoError(json.Unmarshal(msgs[2].Params, &diags))
+	a.Empty(diags.Diagnostics)
+
+	// formatting, the nofmt block is left alone
+	var edits []TextEdit
+	a.Equal(`2`, string(msgs[3].ID))
+	a.NoError(json.Unmarshal(msgs[3].Result, &edits))
+	a.Equal([]TextEdit{
+		{Range: Range{Start: Position{Line: 3}, End: Position{Line: 5}}, NewText: "\tvar s string\n\t// go:nofmt\n"},
+		{Range: Range{Start: Position{Line: 6}, End: Position{Line: 8}}, NewText: "\t// go:fmt\n\tprintln(s, longVariableName)\n"},
+	}, edits)
+
+	// range formatting only formats the lines in the range
+	a.Equal(`3`, string(msgs[4].ID))
+	a.NoError(json.Unmarshal(msgs[4].Result, &edits))
+	a.Equal([]TextEdit{
+		{Range: Range{Start: Position{Line: 7}, End: Position{Line: 8}}, NewText: "\tprintln(s, longVariableName)\n"},
+	}, edits)
+
+	// unsupported request
+	a.Equal(`4`, string(msgs[5].ID))
+	a.Equal(codeMethodNotFound, msgs[5].Error.Code)
+
+	// shutdown, then requests fail
+	a.Equal(`5`, string(msgs[6].ID))
+	a.Equal(`null`, string(msgs[6].Result))
+	a.Equal(`6`, string(msgs[7].ID))
+	a.Equal(codeInvalidRequest, msgs[7].Error.Code)
+}
+
+func TestFormatError(t *testing.T) {
+	a := assert.New(t)
+
+	in := session(
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": testURI, "text": "package main\nfunc {\n"},
+		}),
+		request(1, "textDocument/formatting", map[string]interface{}{"textDocument": map[string]string{"uri": testURI}}),
+		request(2, "textDocument/formatting", map[string]interface{}{"textDocument": map[string]string{"uri": "file:///no/such/file.go"}}),
+	)
+	out := &bytes.Buffer{}
+
+	s := NewServer("gofmt")
+	a.NoError(s.Serve(in, out))
+
+	msgs := responses(t, out)
+	a.Len(msgs, 3)
+	a.Equal(codeRequestFailed, msgs[1].Error.Code)
+	a.Contains(msgs[1].Error.Message, "expected")
+	a.Equal(codeRequestFailed, msgs[2].Error.Code)
+}
+
+func TestTextEdits(t *testing.T) {
+	a := assert.New(t)
+
+	src := "a\nb"
+	edits := textEdits(src, []parser.Edit{{Start: 1, End: 2, Lines: []string{"c\n"}}})
+	a.Equal([]TextEdit{{Range: Range{Start: Position{Line: 1}, End: Position{Line: 1, Character: 1}}, NewText: "c\n"}}, edits)
+
+	a.Equal(2, utf16Len("\U0001F600"))
+	a.Equal(3, utf16Len("aé\n"))
+}
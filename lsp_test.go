@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLsp(t *testing.T) {
+	a := assert.New(t)
+
+	in, err := ioutil.TempFile("", "lsp")
+	a.NoError(err)
+	defer os.Remove(in.Name())
+
+	for _, msg := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	_, err = in.Seek(0, 0)
+	a.NoError(err)
+
+	out, err := ioutil.TempFile("", "lsp")
+	a.NoError(err)
+	defer os.Remove(out.Name())
+
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, out
+	defer func() {
+		os.Stdin, os.Stdout = stdin, stdout
+	}()
+
+	a.Equal(0, lspMain([]string{"lsp", "-F", "gofmt"}))
+
+	data, err := ioutil.ReadFile(out.Name())
+	a.NoError(err)
+	a.Contains(string(data), `"id":1,"result":null`)
+
+	// proxy to a server that exits straight away
+	_, err = in.Seek(0, 0)
+	a.NoError(err)
+	a.Equal(0, lspMain([]string{"lsp", "-proxy", "true"}))
+	a.Equal(2, lspMain([]string{"lsp", "-proxy", "no/such/language/server"}))
+
+	// the server is split as -F is
+	_, err = in.Seek(0, 0)
+	a.NoError(err)
+	a.Equal(0, lspMain([]string{"lsp", "-proxy", `["true", "a b"]`}))
+	_, err = in.Seek(0, 0)
+	a.NoError(err)
+	a.Equal(0, lspMain([]string{"lsp", "-proxy", `'true' "a b"`}))
+	a.Equal(2, lspMain([]string{"lsp", "-proxy", `'true`}))
+
+	flagErrorHandling = flag.ContinueOnError
+	a.Equal(2, lspMain([]string{"lsp", "-no-such-flag"}))
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
 
 	"github.com/debspencer/diff"
 	"github.com/debspencer/nofmt/parser"
@@ -13,11 +19,94 @@ import (
 
 var (
 	exit = os.Exit
+
+	// commands are run instead of formatting when named by the first argument
+	commands = map[string]func(args []string) int{
+		"lsp":    lspMain,
+		"hook":   hookMain,
+		"cache":  cacheMain,
+		"serve":  serveMain,
+		"http":   httpMain,
+		"freeze": freezeMain,
+		"thaw":   thawMain,
+	}
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			exit(cmd(os.Args[1:]))
+			return
+		}
+	}
+
 	opt := getOptions(os.Args)
 
+	// only format the lines changed in git
+	exitStatus := 0
+	var changed map[string][]parser.LineRange
+	if len(opt.diffBase) > 0 || opt.staged {
+		var err error
+		changed, err = gitChangedLines(opt.diffBase, opt.staged, opt.files)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(2)
+			return
+		}
+		if opt.staged && len(changed) > 0 {
+			// the staged lines are those of the index, which are not those
+			// of a file with unstaged changes
+			unstaged, err := gitUnstagedFiles(opt.files)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+				return
+			}
+			for _, file := range changedFiles(changed) {
+				if unstaged[file] {
+					fmt.Fprintf(os.Stderr, "%s: has unstaged changes, not formatting the staged lines\n", file)
+					delete(changed, file)
+					exitStatus = 2
+				}
+			}
+		}
+		if len(changed) == 0 {
+			exit(exitStatus)
+			return
+		}
+		opt.files = changedFiles(changed)
+	}
+
+	fmter := parser.NewFormatterArgs(opt.command)
+	fmter.Filename = opt.stdinName
+	fmter.Env = opt.env
+	fmter.Embedded = embeddedFormatters(opt.embedded)
+	fmter.SkipCheck = opt.noCheck
+	fmter.ForceLF = opt.forceLF
+
+	r := &runner{opt: opt, fmter: fmter}
+
+	// files known to be formatted are skipped
+	if !opt.noCache {
+		r.cache, _ = newCache(opt.command, opt.env, opt.embedded, opt.forceLF)
+	}
+
+	// nofmt serve formats the files if it is running
+	if !opt.noDaemon {
+		r.daemon = dialDaemon()
+	}
+
+	if opt.watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		r.watching = true
+		watch(ctx, opt.files, opt.exts, opt.interval, func(file string) {
+			r.run(file, nil)
+		})
+		exit(0)
+		return
+	}
+
 	files := make(chan string, 16)
 	if len(opt.files) == 0 {
 		opt.write = false
@@ -27,80 +116,284 @@ func main() {
 		}()
 	} else {
 		go func() {
-			walk(files, opt.files)
+			walk(files, opt.files, opt.exts)
 			close(files)
 		}()
 	}
 
-	exitStatus := 0
-
 	for file := range files {
-		fmter := parser.NewFormatter(opt.formatter)
-		stdout := &bytes.Buffer{}
-		stderr := &bytes.Buffer{}
+		lines := opt.ranges
+		if changed != nil {
+			lines = changed[file]
+		}
+		if status := r.run(file, lines); status != 0 {
+			exitStatus = status
+		}
+	}
+	exit(exitStatus)
+}
 
-		var err error
+// runner formats files as the options say
+type runner struct {
+	opt      *options
+	fmter    *parser.Formatter
+	cache    *cache        // nil if the cache is not used
+	daemon   *daemonClient // nil if nofmt serve is not used
+	watching bool          // only write files that change, and list the files written
+}
+
+// run formats file, or stdin if file is empty, and writes, lists or
+// prints it, returning the exit status.  If lines is not empty only those
+// lines are formatted.
+func (r *runner) run(file string, lines []parser.LineRange) int {
+	opt, c := r.opt, r.cache
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	useCache := c != nil && file != "" && len(lines) == 0
+	if useCache {
+		src, err := ioutil.ReadFile(file)
+		if err == nil && c.clean(c.key(src, file)) {
+			if !opt.diff && !opt.write && !opt.list && !opt.verify {
+				os.Stdout.Write(src)
+			}
+			return 0
+		}
+	}
+
+	ctx := context.Background()
+	cancel := func() {}
+	if opt.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opt.timeout)
+	}
+	src, err := r.format(ctx, file, lines, stdout, stderr)
+	cancel()
+	if err == nil && useCache && bytes.Equal(src, stdout.Bytes()) {
+		c.markClean(c.key(src, file))
+	}
+	if err != nil {
+		var synErr *parser.SyntaxError
 		if file == "" {
-			err = fmter.FormatReader(os.Stdin, stdout, stderr)
-		} else {
-			err = fmter.FormatFile(file, stdout, stderr)
+			file = opt.stdinName
+		}
+		if errors.As(err, &synErr) {
+			printSyntaxError(synErr, file)
+			return 2
+		}
+		if file == "" {
+			file = "stdin"
+		}
+		if stderr.Len() > 0 {
+			fmt.Fprint(os.Stderr, stderr.String())
 		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+		return 2
+	}
+
+	if opt.verify {
+		return r.verify(file, stdout.Bytes())
+	}
+
+	if opt.diff {
+		if file == "" {
+			file = opt.stdinName
+		}
+		if file == "" {
+			file = "<stdin>"
+		}
+		b1 := diff.Buffer{Data: src, Filename: file + ".orig"}
+		b2 := diff.Buffer{Data: stdout.Bytes(), Filename: file}
+
+		diffData, err := diff.DiffBuffer(b1, b2)
+
 		if err != nil {
-			if file == "" {
-				file = "stdin"
-			}
-			if stderr.Len() > 0 {
-				fmt.Fprint(os.Stderr, stderr.String())
-			}
-			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
-			exitStatus = 2
-			continue
+			fmt.Fprintf(os.Stderr, "diff failed: %s\n", err)
+			return 2
 		}
+		fmt.Print(string(diffData))
+		return 0
+	}
 
-		if opt.diff {
-			if file == "" {
-				file = "<stdin>"
+	if opt.write {
+		if r.watching {
+			if bytes.Equal(src, stdout.Bytes()) {
+				return 0
 			}
-			b1 := diff.Buffer{Data: fmter.SourceData(), Filename: file + ".orig"}
-			b2 := diff.Buffer{Data: stdout.Bytes(), Filename: file}
+			fmt.Println(file)
+		}
+		mode := os.FileMode(0644)
+		st, err := os.Stat(file)
+		if err == nil {
+			mode = st.Mode()
+		}
+		err = ioutil.WriteFile(file, stdout.Bytes(), mode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rewrite %s: %s\n", file, err)
+			return 2
+		}
+		return 0
+	}
+	if opt.list {
+		if bytes.Compare(src, stdout.Bytes()) != 0 {
+			fmt.Println(file)
+		}
+		return 0
+	}
+	fmt.Print(stdout.String())
+	return 0
+}
+
+// verify formats first, the output of formatting file, again and prints
+// the differences if the second pass changes it, returning the exit
+// status.  Formatting should be idempotent, otherwise the pragmas or
+// the splicing of blocks have gone wrong.
+func (r *runner) verify(file string, first []byte) int {
+	name := file
+	if file == "" {
+		name = r.opt.stdinName
+	}
 
-			diffData, err := diff.DiffBuffer(b1, b2)
+	ctx := context.Background()
+	cancel := func() {}
+	if r.opt.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.opt.timeout)
+	}
+	res, err := r.fmter.FormatContext(ctx, first, name)
+	cancel()
 
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "diff failed: %s\n", err)
-				exitStatus = 2
-			} else {
-				fmt.Print(string(diffData))
+	if name == "" {
+		name = "<stdin>"
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: second pass: %s\n", name, err)
+		return 2
+	}
+	if !res.Changed {
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: formatting is not idempotent\n", name)
+	b1 := diff.Buffer{Data: first, Filename: name + ".pass1"}
+	b2 := diff.Buffer{Data: res.Output, Filename: name + ".pass2"}
+	diffData, err := diff.DiffBuffer(b1, b2)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff failed: %s\n", err)
+		return 2
+	}
+	fmt.Print(string(diffData))
+	return 1
+}
+
+// format formats file, or stdin if file is empty, with nofmt serve if
+// it is running, and returns the source.  If lines is not empty only
+// those lines are formatted.
+func (r *runner) format(ctx context.Context, file string, lines []parser.LineRange, out io.Writer, errOut io.Writer) ([]byte, error) {
+	if r.daemon == nil {
+		err := format(ctx, r.fmter, file, os.Stdin, lines, out, errOut)
+		return r.fmter.SourceData(), err
+	}
+
+	var src []byte
+	var err error
+	if file == "" {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := file
+	if file == "" {
+		name = r.opt.stdinName
+	}
+	req := daemonRequest{
+		Content:   string(src),
+		Env:       r.opt.env,
+		Embedded:  r.opt.embedded,
+		SkipCheck: r.opt.noCheck,
+		ForceLF:   r.opt.forceLF,
+	}
+	if len(name) > 0 {
+		req.Filename, _ = filepath.Abs(name)
+	}
+	formatter, _ := json.Marshal(r.opt.command)
+	req.Formatter = string(formatter)
+	if len(lines) > 0 {
+		req.Lines = formatLineRanges(lines)
+	}
+	if r.opt.timeout > 0 {
+		req.Timeout = r.opt.timeout.String()
+	}
+
+	resp, err := r.daemon.format(ctx, req)
+	if err != nil {
+		// the daemon has gone, format without it
+		r.daemon.close()
+		r.daemon = nil
+		err = format(ctx, r.fmter, file, bytes.NewReader(src), lines, out, errOut)
+		return src, err
+	}
+
+	if len(resp.Errors) > 0 {
+		synErr := &parser.SyntaxError{Errors: resp.Errors}
+		for i, e := range synErr.Errors {
+			if e.File == req.Filename && len(name) > 0 {
+				synErr.Errors[i].File = name
 			}
-			continue
 		}
+		return src, synErr
+	}
+	if len(resp.Error) > 0 {
+		prefix := req.Filename
+		if len(prefix) == 0 {
+			prefix = "<stdin>"
+		}
+		return src, errors.New(strings.TrimPrefix(resp.Error, prefix+": "))
+	}
+	_, err = io.WriteString(out, resp.Output)
+	return src, err
+}
 
-		if opt.write {
-			mode := os.FileMode(0644)
-			st, err := os.Stat(file)
-			if err == nil {
-				mode = st.Mode()
-			}
-			err = ioutil.WriteFile(file, stdout.Bytes(), mode)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "rewrite %s: %s\n", file, err)
-				exitStatus = 2
-			}
-			continue
+// format runs the formatter on file, or in if file is empty.
+// If lines is not empty only those lines are formatted.
+func format(ctx context.Context, fmter *parser.Formatter, file string, in io.Reader, lines []parser.LineRange, out io.Writer, errOut io.Writer) error {
+	if len(lines) == 0 {
+		if file == "" {
+			return fmter.FormatReaderContext(ctx, in, out, errOut)
 		}
-		if opt.list {
-			if bytes.Compare(fmter.SourceData(), stdout.Bytes()) != 0 {
-				fmt.Println(file)
-			}
-			continue
+		return fmter.FormatFileContext(ctx, file, out, errOut)
+	}
+
+	if file != "" {
+		fp, err := os.Open(file)
+		if err != nil {
+			return err
 		}
-		fmt.Print(stdout.String())
+		defer fp.Close()
+		in = fp
+	}
+	return fmter.FormatLinesContext(ctx, in, out, errOut, lines)
+}
+
+// printSyntaxError prints the errors in the source, as the formatter
+// reports them, naming file rather than standard input if it was piped
+func printSyntaxError(synErr *parser.SyntaxError, file string) {
+	for _, e := range synErr.Errors {
+		if e.File == "<standard input>" && file != "" {
+			e.File = file
+		}
+		fmt.Fprintln(os.Stderr, e)
 	}
-	exit(exitStatus)
 }
 
-func walk(ch chan string, files []string) {
+// walk sends files, and the source files below directories, to ch.  The
+// source files are the Go files, or those with one of the extensions exts
+// when given.
+func walk(ch chan string, files []string, exts []string) {
 	for _, file := range files {
+		file = trimDots(file)
 		if len(file) == 0 {
 			continue
 		}
@@ -116,7 +409,7 @@ func walk(ch chan string, files []string) {
 		}
 		if mode.IsDir() {
 			filepath.Walk(file, func(path string, info os.FileInfo, err error) error {
-				if info != nil && info.Mode().IsRegular() && info.Size() > 0 && filepath.Ext(path) == ".go" {
+				if info != nil && info.Mode().IsRegular() && info.Size() > 0 && sourceFile(path, exts) {
 					ch <- path
 				}
 				return nil
@@ -126,3 +419,30 @@ func walk(ch chan string, files []string) {
 		fmt.Fprintf(os.Stderr, "%s unsupport mode %s\n", fi.Name(), mode)
 	}
 }
+
+// sourceFile reports if path, found below a directory, has one of the
+// extensions exts, or is a Go file when there are none
+func sourceFile(path string, exts []string) bool {
+	ext := filepath.Ext(path)
+	if len(exts) == 0 {
+		return ext == ".go"
+	}
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimDots removes a trailing /..., as in go list patterns, since
+// directories are always walked
+func trimDots(file string) string {
+	if file == "..." {
+		return "."
+	}
+	if strings.HasSuffix(file, "/...") {
+		file = strings.TrimSuffix(file, "...")
+	}
+	return file
+}
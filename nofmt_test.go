@@ -6,12 +6,14 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(t *testing.T) {
 	a := assert.New(t)
+	t.Setenv("NOFMTCACHE", t.TempDir())
 
 	exit = func(int) {}
 
@@ -251,6 +253,214 @@ func TestMain(t *testing.T) {
 		a.NotContains(string(b), "+++ "+tmpPath)
 	})
 
+	t.Run("lines", func(t *testing.T) {
+		a := assert.New(t)
+
+		fmtted, stdout, err := os.Pipe()
+		a.NoError(err)
+
+		defer restorOut(setOut(stdout))
+
+		os.Args = []string{"nofmt", "-lines", "6", tmpPath}
+
+		main()
+		stdout.Close()
+
+		b, err := ioutil.ReadAll(fmtted)
+		a.NoError(err)
+		fmtted.Close()
+
+		expected := strings.Replace(string(srcData), "\nfmt.Println(\"hello", "\n\tfmt.Println(\"hello", 1)
+		a.Equal(expected, string(b))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		a := assert.New(t)
+
+		script := filepath.Join(t.TempDir(), "hang.sh")
+		a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 10\n"), 0755))
+
+		errData, stderr, err := os.Pipe()
+		a.NoError(err)
+		defer restorErr(setErr(stderr))
+
+		status := 0
+		exit = func(n int) { status = n }
+		defer func() { exit = func(int) {} }()
+
+		os.Args = []string{"nofmt", "-timeout", "100ms", "-F", script, tmpPath}
+
+		start := time.Now()
+		main()
+		stderr.Close()
+		a.True(time.Since(start) < 5*time.Second)
+		a.Equal(2, status)
+
+		e, err := ioutil.ReadAll(errData)
+		a.NoError(err)
+		errData.Close()
+		a.Contains(string(e), "context deadline exceeded")
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		a := assert.New(t)
+
+		bad := filepath.Join(t.TempDir(), "bad.go")
+		a.NoError(ioutil.WriteFile(bad, []byte("package main\n\nvar a   int\nvar b = \n"), 0644))
+
+		errData, stderr, err := os.Pipe()
+		a.NoError(err)
+		defer restorErr(setErr(stderr))
+
+		status := 0
+		exit = func(n int) { status = n }
+		defer func() { exit = func(int) {} }()
+
+		// the source is piped to the formatter with -lines
+		os.Args = []string{"nofmt", "-lines", "3", bad}
+
+		main()
+		stderr.Close()
+		a.Equal(2, status)
+
+		e, err := ioutil.ReadAll(errData)
+		a.NoError(err)
+		errData.Close()
+		a.Equal(bad+":4:10: expected operand, found 'EOF'\n", string(e))
+	})
+
+	t.Run("stdin filename", func(t *testing.T) {
+		a := assert.New(t)
+
+		stdin, unfmted, err := os.Pipe()
+		a.NoError(err)
+		errData, stderr, err := os.Pipe()
+		a.NoError(err)
+		defer restorIn(setIn(stdin))
+		defer restorErr(setErr(stderr))
+
+		_, err = unfmted.Write([]byte("package main\n\nvar b = \n"))
+		a.NoError(err)
+		unfmted.Close()
+
+		os.Args = []string{"nofmt", "-stdin-filename", "pkg/b.go"}
+
+		main()
+		stderr.Close()
+		stdin.Close()
+
+		e, err := ioutil.ReadAll(errData)
+		a.NoError(err)
+		errData.Close()
+		a.Equal("pkg/b.go:3:10: expected operand, found 'EOF'\n", string(e))
+	})
+
+	t.Run("verify idempotent", func(t *testing.T) {
+		a := assert.New(t)
+
+		status := 0
+		exit = func(n int) { status = n }
+		defer func() { exit = func(int) {} }()
+
+		run := func(args ...string) (string, string) {
+			out, stdout, err := os.Pipe()
+			a.NoError(err)
+			errData, stderr, err := os.Pipe()
+			a.NoError(err)
+			defer restorOut(setOut(stdout))
+			defer restorErr(setErr(stderr))
+
+			os.Args = append([]string{"nofmt", "-verify-idempotent", "-cache=off"}, args...)
+			main()
+			stdout.Close()
+			stderr.Close()
+
+			o, err := ioutil.ReadAll(out)
+			a.NoError(err)
+			e, err := ioutil.ReadAll(errData)
+			a.NoError(err)
+			out.Close()
+			errData.Close()
+			return string(o), string(e)
+		}
+
+		o, e := run(tmpPath)
+		a.Equal(0, status)
+		a.Empty(o)
+		a.Empty(e)
+
+		// a formatter that adds a line every time it is run
+		script := filepath.Join(t.TempDir(), "grow.sh")
+		a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\ncat \"$@\"\necho '// again'\n"), 0755))
+
+		grow := filepath.Join(t.TempDir(), "grow.go")
+		a.NoError(ioutil.WriteFile(grow, []byte("package main\n"), 0644))
+
+		o, e = run("-D", "diff -u", "-F", script, grow)
+		a.Equal(1, status)
+		a.Equal(grow+": formatting is not idempotent\n", e)
+		a.Contains(o, "--- "+grow+".pass1")
+		a.Contains(o, "+++ "+grow+".pass2")
+		a.Contains(o, "+// again")
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		a := assert.New(t)
+
+		md := filepath.Join(t.TempDir(), "README.md")
+		a.NoError(ioutil.WriteFile(md, []byte("# a\n\n    var a   int\n\n```go\nvar a   int\n```\n"), 0644))
+
+		os.Args = []string{"nofmt", "-w", md}
+
+		main()
+
+		b, err := ioutil.ReadFile(md)
+		a.NoError(err)
+		a.Equal("# a\n\n    var a   int\n\n```go\nvar a int\n```\n", string(b))
+	})
+
+	t.Run("embedded", func(t *testing.T) {
+		a := assert.New(t)
+
+		file := filepath.Join(t.TempDir(), "a.go")
+		src := "package a\n\n// go:fmt-embedded json\nvar a = `{\"a\": 1}`\n\n// go:fmt-embedded sql\nvar b = `select 1`\n"
+		a.NoError(ioutil.WriteFile(file, []byte(src), 0644))
+
+		os.Args = []string{"nofmt", "-w", "-cache=off", "-daemon=off", "-embedded", "sql=tr a-z A-Z", file}
+
+		main()
+
+		b, err := ioutil.ReadFile(file)
+		a.NoError(err)
+		a.Equal("package a\n\n// go:fmt-embedded json\nvar a = `{\"a\":1}`\n\n// go:fmt-embedded sql\nvar b = `SELECT 1`\n", string(b))
+	})
+
+	t.Run("ext", func(t *testing.T) {
+		a := assert.New(t)
+
+		dir := t.TempDir()
+		sh := filepath.Join(dir, "a.sh")
+		a.NoError(ioutil.WriteFile(sh, []byte("a=1  # one\n# go:nofmt\nb=2  # two\n# go:fmt\n"), 0644))
+		txt := filepath.Join(dir, "a.txt")
+		a.NoError(ioutil.WriteFile(txt, []byte("a  b\n"), 0644))
+		gofile := filepath.Join(dir, "a.go")
+		a.NoError(ioutil.WriteFile(gofile, []byte("package a\n\nvar a  = 1\n"), 0644))
+
+		os.Args = []string{"nofmt", "-w", "-cache=off", "-daemon=off", "-ext", "sh", "-F", `sed "s/  */ /g" %f`, dir}
+
+		main()
+
+		b, err := ioutil.ReadFile(sh)
+		a.NoError(err)
+		a.Equal("a=1 # one\n# go:nofmt\nb=2  # two\n# go:fmt\n", string(b))
+		b, err = ioutil.ReadFile(txt)
+		a.NoError(err)
+		a.Equal("a  b\n", string(b))
+		b, err = ioutil.ReadFile(gofile)
+		a.NoError(err)
+		a.Equal("package a\n\nvar a  = 1\n", string(b))
+	})
+
 	t.Run("rewrite fail", func(t *testing.T) {
 		a := assert.New(t)
 
@@ -288,7 +498,7 @@ func TestWalk(t *testing.T) {
 
 	ch := make(chan string, 128)
 	go func() {
-		walk(ch, []string{".", "", "/dev/null", "nofmt.go", "no/such/file/or/directory"})
+		walk(ch, []string{".", "", "/dev/null", "nofmt.go", "no/such/file/or/directory"}, nil)
 		close(ch)
 	}()
 
@@ -304,4 +514,26 @@ func TestWalk(t *testing.T) {
 	a.NotContains(sl, "Makefile")
 	a.NotContains(sl, "..")
 	a.NotContains(sl, ".")
+	a.NotContains(sl, "README.md")
+
+	// other extensions are walked instead of .go when asked for
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.sh", "c.SH", "d.txt"} {
+		a.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte("a\n"), 0644))
+	}
+	walked := func(exts []string) []string {
+		ch := make(chan string, 128)
+		go func() {
+			walk(ch, []string{dir}, exts)
+			close(ch)
+		}()
+		var sl []string
+		for s := range ch {
+			sl = append(sl, filepath.Base(s))
+		}
+		return sl
+	}
+	a.Equal([]string{"a.go"}, walked(nil))
+	a.Equal([]string{"b.sh", "c.SH"}, walked([]string{".sh"}))
+	a.Equal([]string{"a.go", "b.sh", "c.SH"}, walked([]string{".go", ".sh"}))
 }
@@ -6,10 +6,15 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/debspencer/diff"
+	"github.com/debspencer/nofmt/parser"
 )
 
+// defaultWatchInterval is how often -watch looks for changes
+const defaultWatchInterval = 500 * time.Millisecond
+
 var (
 	flagErrorHandling = flag.ExitOnError
 	flagErrorHandler  = os.Exit
@@ -23,8 +28,37 @@ type options struct {
 	errors    bool
 	files     []string
 	formatter string
+	command   []string
 	write     bool
 	list      bool
+	lines     string
+	ranges    []parser.LineRange
+	diffBase  string
+	staged    bool
+	timeout   time.Duration
+	stdinName string
+	env       stringList
+	embedded  stringList
+	exts      []string
+	noCache   bool
+	noDaemon  bool
+	noCheck   bool
+	forceLF   bool
+	verify    bool
+	watch     bool
+	interval  time.Duration
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
 
 func getOptions(args []string) *options {
@@ -38,39 +72,169 @@ func getOptions(args []string) *options {
 	f.BoolVar(&o.diff, "d", false, "only show differences")
 	f.StringVar(&o.differ, "D", "", "diff program to use")
 	f.BoolVar(&o.errors, "e", false, "pass -e to formatter program")
+	f.Func("cache", "'on' to skip files that were formatted before, or 'off' (default on)", func(s string) error {
+		switch s {
+		case "on":
+			o.noCache = false
+		case "off":
+			o.noCache = true
+		default:
+			return fmt.Errorf("must be on or off")
+		}
+		return nil
+	})
+	f.Func("ast-check", "'on' to refuse output that is not the same program as the source, or 'off' (default on)", func(s string) error {
+		switch s {
+		case "on":
+			o.noCheck = false
+		case "off":
+			o.noCheck = true
+		default:
+			return fmt.Errorf("must be on or off")
+		}
+		return nil
+	})
+	f.Func("daemon", "'on' to format with nofmt serve if it is running, or 'off' (default on)", func(s string) error {
+		switch s {
+		case "on":
+			o.noDaemon = false
+		case "off":
+			o.noDaemon = true
+		default:
+			return fmt.Errorf("must be on or off")
+		}
+		return nil
+	})
+	f.Var(&o.embedded, "embedded", "format raw strings marked '// go:fmt-embedded name' with 'name=program args', can be repeated")
+	f.Func("ext", "format the files with these extensions, such as '.proto,.sh', found in directories instead of .go files", func(s string) error {
+		for _, ext := range strings.Split(s, ",") {
+			ext = strings.TrimSpace(ext)
+			if len(ext) == 0 {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			o.exts = append(o.exts, ext)
+		}
+		return nil
+	})
+	f.Var(&o.env, "env", "add 'key=value' to the environment of the formatter, can be repeated")
 	f.StringVar(&o.formatter, "F", "gofmt %f", "specify formatter 'program args' (filename will be appended unless %f is used)")
+	f.BoolVar(&o.forceLF, "force-lf", false, "end lines with \\n, rather than keeping \\r\\n line endings")
 	f.BoolVar(&o.list, "l", false, "list all files whose formatting differs from nofmt's")
+	f.StringVar(&o.lines, "lines", "", "only format lines 'start:end,...' of a single file")
+	f.StringVar(&o.diffBase, "diff-base", "", "only format lines changed since git revision")
+	f.BoolVar(&o.staged, "staged", false, "only format lines changed in the git index")
+	f.StringVar(&o.stdinName, "stdin-filename", "", "name of the file read from stdin, the formatter is run in its directory")
+	f.DurationVar(&o.timeout, "timeout", 0, "stop the formatter if a file takes longer than this, 0 for no limit")
 	f.BoolVar(&o.write, "w", false, "write back to file(s) instead of stdout")
+	f.BoolVar(&o.verify, "verify-idempotent", false, "format files twice and show the differences if the second pass changes them")
+	f.BoolVar(&o.watch, "watch", false, "keep watching the files and directories, formatting Go files as they change")
+	f.DurationVar(&o.interval, "watch-interval", defaultWatchInterval, "how often to look for changes with -watch")
 	f.Parse(args[1:])
 	o.files = f.Args()
 
-	if o.write && len(o.files) == 0 {
+	gitDiff := len(o.diffBase) > 0 || o.staged
+
+	if o.write && len(o.files) == 0 && !gitDiff {
 		fmt.Fprintln(os.Stderr, "Can not rewrite <stdin>")
 		o.usage()
 	}
 
-	if countBools(o.diff, o.list, o.write) > 1 {
+	if countBools(o.diff, o.list, o.write, o.verify) > 1 {
+		o.usage()
+	}
+
+	if o.verify && (len(o.lines) > 0 || gitDiff) {
+		fmt.Fprintln(os.Stderr, "Can not use -verify-idempotent with -lines, -diff-base or -staged")
+		o.usage()
+	}
+
+	if len(o.lines) > 0 {
+		var err error
+		o.ranges, err = parser.ParseLineRanges(o.lines)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			o.usage()
+		}
+		if len(o.files) > 1 {
+			fmt.Fprintln(os.Stderr, "Can only format lines of a single file")
+			o.usage()
+		}
+		if gitDiff {
+			fmt.Fprintln(os.Stderr, "Can not use -lines with -diff-base or -staged")
+			o.usage()
+		}
+	}
+
+	if len(o.stdinName) > 0 && (len(o.files) > 0 || gitDiff) {
+		fmt.Fprintln(os.Stderr, "Can only use -stdin-filename with stdin")
 		o.usage()
 	}
 
+	for _, env := range o.env {
+		if !strings.Contains(env, "=") {
+			fmt.Fprintf(os.Stderr, "Bad -env %q, must be key=value\n", env)
+			o.usage()
+		}
+	}
+
+	for _, e := range o.embedded {
+		name, command, ok := strings.Cut(e, "=")
+		if !ok || len(name) == 0 || strings.ContainsAny(name, " \t") {
+			fmt.Fprintf(os.Stderr, "Bad -embedded %q, must be name=program args\n", e)
+			o.usage()
+		}
+		args, err := parser.ParseCommand(command)
+		if err != nil || len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Bad -embedded program %q\n", command)
+			o.usage()
+		}
+	}
+
+	if o.watch {
+		if len(o.files) == 0 || gitDiff || len(o.lines) > 0 {
+			fmt.Fprintln(os.Stderr, "Can only -watch files and directories")
+			o.usage()
+		}
+		if countBools(o.diff, o.list, o.write) == 0 {
+			fmt.Fprintln(os.Stderr, "Use -watch with -d, -l or -w")
+			o.usage()
+		}
+		if o.interval <= 0 {
+			fmt.Fprintln(os.Stderr, "The -watch-interval must be more than 0")
+			o.usage()
+		}
+	}
+
 	if o.list && len(o.files) == 0 {
 		o.files = []string{"."}
 	}
 
-	if o.errors {
-		if strings.Contains(o.formatter, " ") {
-			o.formatter = strings.Replace(o.formatter, " ", " -e ", 1)
-		} else {
-			o.formatter += " -e"
+	command, err := parser.ParseCommand(o.formatter)
+	if err != nil || len(command) == 0 {
+		fmt.Fprintf(os.Stderr, "Bad formatter %q\n", o.formatter)
+		o.usage()
+	} else {
+		o.command = command
+		if o.errors {
+			// -e follows the program name
+			o.command = append([]string{command[0], "-e"}, command[1:]...)
 		}
 	}
 
-	if o.diff && len(o.differ) > 0 {
-		s := strings.Fields(o.differ)
+	if (o.diff || o.verify) && len(o.differ) > 0 {
+		s, err := parser.SplitCommand(o.differ)
+		if err != nil || len(s) == 0 {
+			fmt.Fprintf(os.Stderr, "Bad diff program %q\n", o.differ)
+			o.usage()
+			return o
+		}
 		diff.DiffProgram = s[0]
 
 		if len(s) > 1 {
-			diff.DiffProgramArgs = strings.Join(s[1:], " ")
+			diff.DiffProgramArgs = parser.JoinCommand(s[1:])
 		} else {
 			diff.DiffProgramArgs = ""
 		}
@@ -80,7 +244,16 @@ func getOptions(args []string) *options {
 }
 
 func (o *options) usage() {
-	fmt.Fprintf(os.Stderr, "usage: %s [-d|-w|-l] [-D <diffprog>] [-e] [-F <fmter>] [-n] [file|dir ...]\n", filepath.Base(o.args[0]))
+	prog := filepath.Base(o.args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s [-d|-w|-l] [-D <diffprog>] [-ast-check on|off] [-cache on|off] [-daemon on|off] [-e] [-F <fmter>] [-force-lf] [-lines <ranges>] [-diff-base <rev>] [-staged] [-timeout <duration>] [-env <key=value>] [-embedded <name=fmter>] [-ext <exts>] [-stdin-filename <name>] [-watch [-watch-interval <duration>]] [-verify-idempotent] [file|dir ...]\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s lsp [-F <fmter>] [-proxy <server>]\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s hook install [-f] [-restage] [-hooks-path <dir>] [-F <fmter>]\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s hook run [-restage] [-F <fmter>]\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s cache clean|dir\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s serve [-F <fmter>] [-socket <path>]\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s http [-addr <host:port>] [-F <fmter>] [-timeout <duration>]\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s freeze [-l] [-F <fmter>] file|dir ...\n", prog)
+	fmt.Fprintf(os.Stderr, "       %s thaw [-l] [-F <fmter>] file|dir ...\n", prog)
 	o.f.PrintDefaults()
 	flagErrorHandler(2)
 }
@@ -94,3 +267,20 @@ func countBools(bools ...bool) int {
 	}
 	return n
 }
+
+// embeddedFormatters returns the formatters of -embedded, which are
+// name=command, by name
+func embeddedFormatters(embedded []string) map[string]parser.EmbeddedFormatter {
+	if len(embedded) == 0 {
+		return nil
+	}
+	formatters := make(map[string]parser.EmbeddedFormatter)
+	for _, e := range embedded {
+		name, command, _ := strings.Cut(e, "=")
+		args, err := parser.ParseCommand(command)
+		if err == nil {
+			formatters[name] = parser.EmbeddedCommand(args)
+		}
+	}
+	return formatters
+}
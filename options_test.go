@@ -4,7 +4,10 @@ import (
 	"flag"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/debspencer/diff"
+	"github.com/debspencer/nofmt/parser"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,17 +29,51 @@ func TestOptions(t *testing.T) {
 		opt   options
 		error bool
 	}{
-		{flags: "", opt: options{formatter: "gofmt %f"}},
-		{flags: "-d", opt: options{formatter: "gofmt %f", diff: true}},
-		{flags: "-w", opt: options{formatter: "gofmt %f", write: true}, error: true},
-		{flags: "-l", opt: options{formatter: "gofmt %f", list: true, files: []string{"."}}},
-		{flags: "-d -l file", opt: options{formatter: "gofmt %f", diff: true, list: true, files: []string{"file"}}, error: true},
-		{flags: "-l -w file", opt: options{formatter: "gofmt %f", list: true, write: true, files: []string{"file"}}, error: true},
-		{flags: "-d -w file", opt: options{formatter: "gofmt %f", diff: true, write: true, files: []string{"file"}}, error: true},
-		{flags: "-d -D diff_-u a b", opt: options{formatter: "gofmt %f", diff: true, differ: "diff -u", files: []string{"a", "b"}}},
-		{flags: "-w -F myfmt file", opt: options{formatter: "myfmt", write: true, files: []string{"file"}}},
-		{flags: "-e", opt: options{formatter: "gofmt -e %f", errors: true}},
-		{flags: "-w -e -F myfmt file", opt: options{formatter: "myfmt -e", errors: true, write: true, files: []string{"file"}}},
+		{flags: "", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}}},
+		{flags: "-d", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, diff: true}},
+		{flags: "-w", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, write: true}, error: true},
+		{flags: "-l", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, list: true, files: []string{"."}}},
+		{flags: "-d -l file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, diff: true, list: true, files: []string{"file"}}, error: true},
+		{flags: "-l -w file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, list: true, write: true, files: []string{"file"}}, error: true},
+		{flags: "-d -w file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, diff: true, write: true, files: []string{"file"}}, error: true},
+		{flags: "-d -D diff_-u a b", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, diff: true, differ: "diff -u", files: []string{"a", "b"}}},
+		{flags: "-w -F myfmt file", opt: options{formatter: "myfmt", command: []string{"myfmt"}, write: true, files: []string{"file"}}},
+		{flags: "-e", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "-e", "%f"}, errors: true}},
+		{flags: "-w -e -F myfmt file", opt: options{formatter: "myfmt", command: []string{"myfmt", "-e"}, errors: true, write: true, files: []string{"file"}}},
+		{flags: "-lines 10:40,88:90 file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, lines: "10:40,88:90", ranges: []parser.LineRange{{Start: 10, End: 40}, {Start: 88, End: 90}}, files: []string{"file"}}},
+		{flags: "-lines 10:40 a b", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, lines: "10:40", ranges: []parser.LineRange{{Start: 10, End: 40}}, files: []string{"a", "b"}}, error: true},
+		{flags: "-lines 40:10", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, lines: "40:10"}, error: true},
+		{flags: "-w -diff-base HEAD~1", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, write: true, diffBase: "HEAD~1"}},
+		{flags: "-l -staged dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, list: true, staged: true, files: []string{"dir"}}},
+		{flags: "-e -F '/opt/my_fmt'_-s_%f file", opt: options{formatter: "'/opt/my fmt' -s %f", command: []string{"/opt/my fmt", "-e", "-s", "%f"}, errors: true, files: []string{"file"}}},
+		{flags: `-F ["my_fmt",_"-local",_"a_b"] file`, opt: options{formatter: `["my fmt", "-local", "a b"]`, command: []string{"my fmt", "-local", "a b"}, files: []string{"file"}}},
+		{flags: `-F "gofmt file`, opt: options{formatter: `"gofmt`, files: []string{"file"}}, error: true},
+		{flags: "-d -D '/opt/my_diff'_-u a", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, diff: true, differ: "'/opt/my diff' -u", files: []string{"a"}}},
+		{flags: "-env GOFLAGS=-mod=mod -env A=b_c file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, env: stringList{"GOFLAGS=-mod=mod", "A=b c"}, files: []string{"file"}}},
+		{flags: "-env GOFLAGS file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, env: stringList{"GOFLAGS"}, files: []string{"file"}}, error: true},
+		{flags: "-embedded sql=sqlfmt_-s_2 file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, embedded: stringList{"sql=sqlfmt -s 2"}, files: []string{"file"}}},
+		{flags: "-embedded sqlfmt file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, embedded: stringList{"sqlfmt"}, files: []string{"file"}}, error: true},
+		{flags: "-embedded sql= file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, embedded: stringList{"sql="}, files: []string{"file"}}, error: true},
+		{flags: "-ext .proto,sh -ext .c file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, exts: []string{".proto", ".sh", ".c"}, files: []string{"file"}}},
+		{flags: "-stdin-filename pkg/a.go", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, stdinName: "pkg/a.go"}},
+		{flags: "-stdin-filename pkg/a.go file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, stdinName: "pkg/a.go", files: []string{"file"}}, error: true},
+		{flags: "-cache=off file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, noCache: true, files: []string{"file"}}},
+		{flags: "-ast-check=off file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, noCheck: true, files: []string{"file"}}},
+		{flags: "-force-lf file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, forceLF: true, files: []string{"file"}}},
+		{flags: "-daemon=off file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, noDaemon: true, files: []string{"file"}}},
+		{flags: "-cache=maybe file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, files: []string{"file"}}, error: true},
+		{flags: "-timeout 1m30s file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, timeout: 90 * time.Second, files: []string{"file"}}},
+		{flags: "-timeout soon file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, files: []string{"file"}}, error: true},
+		{flags: "-watch -w ./...", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, watch: true, write: true, files: []string{"./..."}}},
+		{flags: "-watch -l -watch-interval 2s dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, watch: true, list: true, interval: 2 * time.Second, files: []string{"dir"}}},
+		{flags: "-watch dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, watch: true, files: []string{"dir"}}, error: true},
+		{flags: "-watch -w", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, watch: true, write: true}, error: true},
+		{flags: "-watch -w -staged dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, watch: true, write: true, staged: true, files: []string{"dir"}}, error: true},
+		{flags: "-watch -w -watch-interval -1s dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, watch: true, write: true, interval: -time.Second, files: []string{"dir"}}, error: true},
+		{flags: "-verify-idempotent dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, verify: true, files: []string{"dir"}}},
+		{flags: "-verify-idempotent -w dir", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, verify: true, write: true, files: []string{"dir"}}, error: true},
+		{flags: "-verify-idempotent -lines 3 file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, verify: true, lines: "3", ranges: []parser.LineRange{{Start: 3, End: 3}}, files: []string{"file"}}, error: true},
+		{flags: "-staged -lines 1:2 file", opt: options{formatter: "gofmt %f", command: []string{"gofmt", "%f"}, staged: true, lines: "1:2", ranges: []parser.LineRange{{Start: 1, End: 2}}, files: []string{"file"}}, error: true},
 	}
 	for _, test := range tests {
 		testFlagError = 0
@@ -44,6 +81,9 @@ func TestOptions(t *testing.T) {
 			if test.opt.files == nil {
 				test.opt.files = []string{}
 			}
+			if test.opt.interval == 0 {
+				test.opt.interval = defaultWatchInterval
+			}
 			prog := strings.TrimSpace("prog " + test.flags)
 
 			opts := strings.Fields(prog)
@@ -61,3 +101,25 @@ func TestOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestOptionsDiffProgram(t *testing.T) {
+	a := assert.New(t)
+	flagErrorHandling = flag.ContinueOnError
+	flagErrorHandler = testErrorHandler
+
+	program, args := diff.DiffProgram, diff.DiffProgramArgs
+	defer func() { diff.DiffProgram, diff.DiffProgramArgs = program, args }()
+
+	getOptions([]string{"prog", "-d", "-D", `'/opt/my diff' -u  -b`, "a"})
+	a.Equal("/opt/my diff", diff.DiffProgram)
+	a.Equal("-u -b", diff.DiffProgramArgs)
+
+	// arguments keep their quoting
+	getOptions([]string{"prog", "-d", "-D", `diff -u -I "^// a b"`, "a"})
+	a.Equal("diff", diff.DiffProgram)
+	a.Equal(`-u -I '^// a b'`, diff.DiffProgramArgs)
+
+	testFlagError = 0
+	getOptions([]string{"prog", "-d", "-D", `"diff`, "a"})
+	a.Equal(2, testFlagError)
+}
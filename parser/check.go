@@ -0,0 +1,230 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	goparser "go/parser"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// EquivalenceError is returned when the formatted output is not the same
+// program as the source, nor as the output of the formatter, such as
+// when lines are lost or repeated where blocks are spliced together.
+// Nothing should be written when it is returned.
+type EquivalenceError struct {
+	Line    int // line of the output where it first differs
+	Message string
+}
+
+func (e *EquivalenceError) Error() string {
+	return fmt.Sprintf("output line %d: %s, not writing it", e.Line, e.Message)
+}
+
+var (
+	posType          = reflect.TypeOf(token.NoPos)
+	objectType       = reflect.TypeOf((*ast.Object)(nil))
+	scopeType        = reflect.TypeOf((*ast.Scope)(nil))
+	commentGroupType = reflect.TypeOf((*ast.CommentGroup)(nil))
+	basicLitType     = reflect.TypeOf(ast.BasicLit{})
+	nodeType         = reflect.TypeOf((*ast.Node)(nil)).Elem()
+)
+
+// checkEquivalent checks that out, the merged output of formatting src,
+// parses to the same program as src, or as formatted, the output of the
+// formatter, which may change the program itself, as goimports does.
+// Positions, the whitespace of comments and how literals are written
+// are ignored.  If src is not Go that go/parser understands there is
+// nothing to compare, and if out is formatted nothing was spliced.
+func checkEquivalent(src []byte, formatted []byte, out []byte) error {
+	if bytes.Equal(out, formatted) {
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	srcFile, err := goparser.ParseFile(fset, "", src, goparser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	outFile, err := goparser.ParseFile(fset, "", out, goparser.ParseComments)
+	if err != nil {
+		e := &EquivalenceError{Message: "does not parse: " + err.Error()}
+		if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+			e.Message = "does not parse: " + list[0].Msg
+			e.Line = list[0].Pos.Line
+		}
+		return e
+	}
+
+	pos, same := equalFiles(srcFile, outFile)
+	if same {
+		return nil
+	}
+	if fmtFile, err := goparser.ParseFile(token.NewFileSet(), "", formatted, goparser.ParseComments); err == nil {
+		if _, same := equalFiles(fmtFile, outFile); same {
+			return nil
+		}
+	}
+	return &EquivalenceError{
+		Line:    fset.Position(pos).Line,
+		Message: "does not match the source",
+	}
+}
+
+// equalFiles compares the declarations and comments of a and b.  If they
+// differ the position in b of the first difference is returned.
+func equalFiles(a, b *ast.File) (token.Pos, bool) {
+	pos, same := equalValues(reflect.ValueOf(a), reflect.ValueOf(b), b.Pos())
+	if !same {
+		return pos, false
+	}
+
+	var ac, bc []*ast.Comment
+	for _, g := range a.Comments {
+		ac = append(ac, g.List...)
+	}
+	for _, g := range b.Comments {
+		bc = append(bc, g.List...)
+	}
+	for i := range bc {
+		if i >= len(ac) || strings.Join(strings.Fields(ac[i].Text), " ") != strings.Join(strings.Fields(bc[i].Text), " ") {
+			return bc[i].Pos(), false
+		}
+	}
+	if len(ac) > len(bc) {
+		return b.End(), false
+	}
+	return token.NoPos, true
+}
+
+// equalValues compares a and b, parts of a syntax tree, skipping
+// positions, comments and resolved objects.  pos is the position of the
+// node of b that holds them, returned if they differ, unless a more
+// precise one is found.
+func equalValues(a, b reflect.Value, pos token.Pos) (token.Pos, bool) {
+	if a.Type() != b.Type() {
+		return pos, false
+	}
+	if b.Type().Implements(nodeType) && !isNil(b) {
+		if p := b.Interface().(ast.Node).Pos(); p.IsValid() {
+			pos = p
+		}
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return pos, a.IsNil() == b.IsNil()
+		}
+		return equalValues(a.Elem(), b.Elem(), pos)
+
+	case reflect.Slice:
+		for i := 0; i < a.Len() && i < b.Len(); i++ {
+			if p, same := equalValues(a.Index(i), b.Index(i), pos); !same {
+				return p, false
+			}
+		}
+		if a.Len() < b.Len() {
+			return nodePos(b.Index(a.Len()), pos), false
+		}
+		if a.Len() > b.Len() {
+			if b.Len() > 0 {
+				return nodeEnd(b.Index(b.Len()-1), pos), false
+			}
+			return pos, false
+		}
+		return pos, true
+
+	case reflect.Struct:
+		if a.Type() == basicLitType {
+			return pos, equalLiterals(a.Addr().Interface().(*ast.BasicLit), b.Addr().Interface().(*ast.BasicLit))
+		}
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			switch field.Type {
+			case posType, objectType, scopeType, commentGroupType:
+				continue
+			}
+			if a.Type() == reflect.TypeOf(ast.File{}) {
+				switch field.Name {
+				case "Comments", "Imports", "Unresolved":
+					continue // compared separately, or repeat the declarations
+				}
+			}
+			if p, same := equalValues(a.Field(i), b.Field(i), pos); !same {
+				return p, false
+			}
+		}
+		return pos, true
+
+	case reflect.String:
+		return pos, a.String() == b.String()
+	case reflect.Bool:
+		return pos, a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return pos, a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return pos, a.Uint() == b.Uint()
+	}
+	return pos, true
+}
+
+// equalLiterals reports if a and b have the same value, however they are
+// written, as gofmt rewrites 0X1 as 0x1
+func equalLiterals(a, b *ast.BasicLit) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	if a.Value == b.Value {
+		return true
+	}
+	av := constant.MakeFromLiteral(a.Value, a.Kind, 0)
+	bv := constant.MakeFromLiteral(b.Value, b.Kind, 0)
+	if av.Kind() == constant.Unknown || bv.Kind() == constant.Unknown {
+		return false
+	}
+	return constant.Compare(av, token.EQL, bv)
+}
+
+// nodePos returns the position of v if it is a node, otherwise pos
+func nodePos(v reflect.Value, pos token.Pos) token.Pos {
+	if v.Type().Implements(nodeType) && !isNil(v) {
+		if p := v.Interface().(ast.Node).Pos(); p.IsValid() {
+			return p
+		}
+	}
+	return pos
+}
+
+// nodeEnd returns the end of v if it is a node, otherwise pos
+func nodeEnd(v reflect.Value, pos token.Pos) token.Pos {
+	if v.Type().Implements(nodeType) && !isNil(v) {
+		if p := v.Interface().(ast.Node).End(); p.IsValid() {
+			return p
+		}
+	}
+	return pos
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// joinBlocks returns the source of blocks
+func joinBlocks(blocks []*block) []byte {
+	var b strings.Builder
+	for _, bl := range blocks {
+		for _, l := range bl.lines {
+			b.WriteString(l)
+		}
+	}
+	return []byte(b.String())
+}
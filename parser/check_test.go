@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckEquivalent(t *testing.T) {
+	src := "package a\n\n// go:nofmt\nvar a   = 0X1F // hex\n// go:fmt\n\nfunc f() {\n\tb := `x`\n\t_ = b\n}\n"
+
+	tests := []struct {
+		name      string
+		formatted string
+		out       string
+		line      int // 0 if equivalent
+	}{
+		{name: "same", out: src},
+		{name: "whitespace", out: "package a\n\n// go:nofmt\nvar a = 0X1F //   hex\n// go:fmt\n\nfunc f() {\n b := `x`\n _ = b\n}\n"},
+		{name: "literal", out: "package a\n\n// go:nofmt\nvar a = 0x1f // hex\n// go:fmt\n\nfunc f() {\n\tb := \"x\"\n\t_ = b\n}\n"},
+		{name: "dropped line", out: "package a\n\n// go:nofmt\nvar a   = 0X1F // hex\n// go:fmt\n\nfunc f() {\n\tb := `x`\n}\n", line: 8},
+		{name: "repeated line", out: "package a\n\n// go:nofmt\nvar a   = 0X1F // hex\nvar a   = 0X1F // hex\n// go:fmt\n\nfunc f() {\n\tb := `x`\n\t_ = b\n}\n", line: 5},
+		{name: "comment", out: "package a\n\n// go:nofmt\nvar a   = 0X1F // hex!\n// go:fmt\n\nfunc f() {\n\tb := `x`\n\t_ = b\n}\n", line: 4},
+		{name: "not parsed", out: "package a\n\n// go:nofmt\nvar a   = 0X1F // hex\n// go:fmt\n\nfunc f() {\n\tb := `x`\n", line: 8},
+		{
+			name:      "formatter changes",
+			formatted: "package a\n\nimport \"fmt\"\n\n// go:nofmt\nvar a = 0x1f // hex\n// go:fmt\n\nfunc f() {\n\tfmt.Println()\n}\n",
+			out:       "package a\n\nimport \"fmt\"\n\n// go:nofmt\nvar a   = 0X1F // hex\n// go:fmt\n\nfunc f() {\n\tfmt.Println()\n}\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkEquivalent([]byte(src), []byte(test.formatted), []byte(test.out))
+			if test.line == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var eqErr *EquivalenceError
+			if assert.True(t, errors.As(err, &eqErr), "%v", err) {
+				assert.Equal(t, test.line, eqErr.Line)
+			}
+		})
+	}
+
+	// not Go, or already what the formatter wrote
+	assert.NoError(t, checkEquivalent([]byte("select 1\n"), nil, []byte("SELECT 1\n")))
+	assert.NoError(t, checkEquivalent([]byte("package a\n"), []byte("not go\n"), []byte("not go\n")))
+}
+
+func TestFormatEquivalence(t *testing.T) {
+	a := assert.New(t)
+
+	// the formatter moves the go:nofmt block after its go:fmt, so the
+	// block is repeated when spliced
+	script := filepath.Join(t.TempDir(), "move.sh")
+	a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\ncat >/dev/null\nprintf 'package a\\n// go:nofmt\\n// go:fmt\\nvar b = 1\\nvar a int\\n'\n"), 0755))
+	src := "package a\n// go:nofmt\nvar a   int\n// go:fmt\nvar b = 1\n"
+
+	f := NewFormatter(script)
+	_, err := f.Format([]byte(src), "")
+	var eqErr *EquivalenceError
+	if a.True(errors.As(err, &eqErr), "%v", err) {
+		a.Equal(6, eqErr.Line)
+		a.Equal("<stdin>: output line 6: does not match the source, not writing it", err.Error())
+	}
+
+	f.SkipCheck = true
+	res, err := f.Format([]byte(src), "")
+	a.NoError(err)
+	a.Equal("package a\n// go:nofmt\nvar a   int\n// go:fmt\nvar b = 1\nvar a int\n", string(res.Output))
+
+	_, err = Merge([]byte(src), res.Output[:0])
+	a.Error(err)
+}
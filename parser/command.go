@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ParseCommand parses a formatter or diff command line.  A command
+// starting with [ is a JSON array of the program and its arguments, such
+// as ["goimports", "-local", "example.com/a b", "%f"], otherwise it is
+// split into words as by SplitCommand.
+func ParseCommand(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var args []string
+		err := json.Unmarshal([]byte(s), &args)
+		if err != nil {
+			return nil, fmt.Errorf("bad command %s: %s", s, err)
+		}
+		return args, nil
+	}
+	return SplitCommand(s)
+}
+
+// SplitCommand splits s into words as a shell would, without expanding
+// anything.  Words are separated by white space, which can be quoted with
+// single or double quotes, or escaped with a backslash.  Within double
+// quotes a backslash only escapes ", \, $, ` and newline.
+func SplitCommand(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false // a word has been started, it may be an empty quoted word
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return nil, fmt.Errorf("bad command %s: ends with \\", s)
+			}
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("bad command %s: unterminated '", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("bad command %s: unterminated \"", s)
+			}
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// JoinCommand joins args into a command line that SplitCommand splits
+// back into args, single quoting the arguments that need it
+func JoinCommand(args []string) string {
+	words := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > 0 && !strings.ContainsAny(arg, " \t\r\n'\"\\`$") {
+			words[i] = arg
+		} else {
+			words[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// commandArgs returns the arguments of command for file.  %f in each
+// argument is replaced with file, and file is appended if no argument has
+// %f.  %n is replaced with the base name of name, the name of the source,
+// and %d with dir, its directory.  When file is blank, for standard input,
+// an argument that is only %f is dropped, as is an argument of only %n or
+// %d when name is blank.
+func commandArgs(command []string, file string, name string, dir string) []string {
+	base := ""
+	if len(name) > 0 {
+		base = filepath.Base(name)
+		if len(dir) == 0 {
+			dir = filepath.Dir(name)
+		}
+	}
+	r := strings.NewReplacer("%f", file, "%n", base, "%d", dir)
+
+	args := make([]string, 0, len(command)+1)
+	found := false
+	for _, arg := range command {
+		if strings.Contains(arg, "%f") {
+			found = true
+		}
+		if (arg == "%f" && file == "") || ((arg == "%n" || arg == "%d") && name == "") {
+			continue
+		}
+		args = append(args, r.Replace(arg))
+	}
+	if !found && file != "" {
+		args = append(args, file)
+	}
+	return args
+}
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in    string
+		out   []string
+		error bool
+	}{
+		{in: "", out: nil},
+		{in: "gofmt", out: []string{"gofmt"}},
+		{in: "  gofmt   -s\t%f ", out: []string{"gofmt", "-s", "%f"}},
+		{in: `goimports -local "a b" %f`, out: []string{"goimports", "-local", "a b", "%f"}},
+		{in: `'/opt/my tools/fmt' -x`, out: []string{"/opt/my tools/fmt", "-x"}},
+		{in: `my\ fmt a\\b`, out: []string{"my fmt", `a\b`}},
+		{in: `a "" ''`, out: []string{"a", "", ""}},
+		{in: `a"b c"'d e'f`, out: []string{"ab cd ef"}},
+		{in: `"a \"b\" \c \$"`, out: []string{`a "b" \c $`}},
+		{in: "a \\\nb", out: []string{"a", "b"}},
+		{in: `a "b`, error: true},
+		{in: `a 'b`, error: true},
+		{in: `a \`, error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			out, err := SplitCommand(test.in)
+			if test.error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.out, out)
+		})
+	}
+}
+
+func TestJoinCommand(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("diff -u", JoinCommand([]string{"diff", "-u"}))
+	a.Equal(`diff -I '^// a b$' '' 'it'\''s'`, JoinCommand([]string{"diff", "-I", "^// a b$", "", "it's"}))
+
+	for _, args := range [][]string{
+		{"/opt/my diff", "-u"},
+		{"a\tb", `c\d`, `"e"`, "", "f'g"},
+	} {
+		out, err := SplitCommand(JoinCommand(args))
+		a.NoError(err)
+		a.Equal(args, out)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	a := assert.New(t)
+
+	args, err := ParseCommand(` ["my fmt", "-local", "a b", "%f"]`)
+	a.NoError(err)
+	a.Equal([]string{"my fmt", "-local", "a b", "%f"}, args)
+
+	_, err = ParseCommand(`["gofmt", `)
+	a.Error(err)
+
+	args, err = ParseCommand(`gofmt -s`)
+	a.NoError(err)
+	a.Equal([]string{"gofmt", "-s"}, args)
+}
+
+func TestCommandArgs(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{"gofmt", "a b.go"}, commandArgs([]string{"gofmt", "%f"}, "a b.go", "", ""))
+	a.Equal([]string{"gofmt"}, commandArgs([]string{"gofmt", "%f"}, "", "", ""))
+	a.Equal([]string{"gofmt", "a.go"}, commandArgs([]string{"gofmt"}, "a.go", "", ""))
+	a.Equal([]string{"gofmt"}, commandArgs([]string{"gofmt"}, "", "", ""))
+	a.Equal([]string{"fmt", "-in=a.go", "-x"}, commandArgs([]string{"fmt", "-in=%f", "-x"}, "a.go", "", ""))
+	a.Equal([]string{"fmt", "-in=", "-x"}, commandArgs([]string{"fmt", "-in=%f", "-x"}, "", "", ""))
+
+	goimports := []string{"goimports", "-srcdir", "%d", "-name=%n"}
+	a.Equal([]string{"goimports", "-srcdir", "/src/a", "-name=b.go"}, commandArgs(goimports, "", "a/b.go", "/src/a"))
+	a.Equal([]string{"goimports", "-srcdir", "a", "-name=b.go", "b.go"}, commandArgs(goimports, "b.go", "a/b.go", ""))
+	a.Equal([]string{"goimports", "-srcdir", "-name="}, commandArgs(goimports, "", "", ""))
+	a.Equal([]string{"fmt"}, commandArgs([]string{"fmt", "%n", "%d"}, "", "", ""))
+}
+
+func TestFormatterDir(t *testing.T) {
+	a := assert.New(t)
+
+	// the script reports its directory, arguments and environment
+	dir := t.TempDir()
+	script := filepath.Join(dir, "pwd.sh")
+	a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\ncat >/dev/null\necho \"// $(pwd) $* $NOFMT_TEST\"\n"), 0755))
+	sub := filepath.Join(dir, "sub")
+	a.NoError(os.Mkdir(sub, 0755))
+
+	f := NewFormatterArgs([]string{script, "-srcdir", "%d", "%n"})
+	f.Env = []string{"NOFMT_TEST=env"}
+	res, err := f.Format([]byte("package a\n"), filepath.Join(sub, "a.go"))
+	a.NoError(err)
+	a.Equal(fmt.Sprintf("// %s -srcdir %s a.go env\n", sub, sub), string(res.Output))
+
+	// a missing directory runs in the current one
+	cwd, err := os.Getwd()
+	a.NoError(err)
+	res, err = f.Format([]byte("package a\n"), "missing/a.go")
+	a.NoError(err)
+	a.Equal(fmt.Sprintf("// %s -srcdir missing a.go env\n", cwd), string(res.Output))
+
+	// FormatReader uses Filename
+	var out, errOut bytes.Buffer
+	f.Filename = filepath.Join(sub, "b.go")
+	a.NoError(f.FormatReader(strings.NewReader("package a\n"), &out, &errOut))
+	a.Equal(fmt.Sprintf("// %s -srcdir %s b.go env\n", sub, sub), out.String())
+
+	// a relative program path is from the current directory
+	rel, err := filepath.Rel(cwd, script)
+	a.NoError(err)
+	f = NewFormatter(rel)
+	res, err = f.Format([]byte("package a\n"), filepath.Join(sub, "a.go"))
+	a.NoError(err)
+	a.Equal(fmt.Sprintf("// %s  \n", sub), string(res.Output))
+}
+
+func TestFormatterSpaces(t *testing.T) {
+	a := assert.New(t)
+
+	// a formatter and a file with spaces in their paths
+	dir := filepath.Join(t.TempDir(), "my dir")
+	a.NoError(os.Mkdir(dir, 0755))
+	script := filepath.Join(dir, "my fmt")
+	a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\nexec gofmt \"$@\"\n"), 0755))
+	file := filepath.Join(dir, "a b.go")
+	a.NoError(ioutil.WriteFile(file, []byte("package a\n\nvar a   int\n"), 0644))
+
+	for _, f := range []*Formatter{
+		NewFormatter(`"` + script + `" -s %f`),
+		NewFormatterArgs([]string{script, "-s", "%f"}),
+	} {
+		var out, errOut bytes.Buffer
+		a.NoError(f.FormatFile(file, &out, &errOut))
+		a.Equal("package a\n\nvar a int\n", out.String())
+	}
+
+	var out, errOut bytes.Buffer
+	a.Error(NewFormatter(`"gofmt`).FormatFile(file, &out, &errOut))
+	a.Error(NewFormatter("  ").FormatFile(file, &out, &errOut))
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Edit replaces the lines Start up to, but not including, End of the
+// original text with Lines.  Line numbers start at 0 and each line
+// keeps its trailing newline.
+type Edit struct {
+	Start int
+	End   int
+	Lines []string
+}
+
+// Edits returns the minimal set of line edits that will turn src into dst.
+// Edits are returned in order and do not overlap.
+func Edits(src, dst []byte) []Edit {
+	return diffLines(splitLines(string(src)), splitLines(string(dst)))
+}
+
+// splitLines will split s into lines, keeping the newline on each line.
+// A final line without a newline is kept.
+func splitLines(s string) []string {
+	lines := make([]string, 0, strings.Count(s, "\n")+1)
+	for len(s) > 0 {
+		n := strings.IndexByte(s, '\n') + 1
+		if n == 0 {
+			n = len(s)
+		}
+		lines = append(lines, s[:n])
+		s = s[n:]
+	}
+	return lines
+}
+
+// diffLines computes the edits from a to b using Myers' O(ND) algorithm.
+func diffLines(a, b []string) []Edit {
+	// strip the common prefix and suffix, for formatting changes this is
+	// usually most of the file
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	a = a[pre : len(a)-suf]
+	b = b[pre : len(b)-suf]
+	n, m := len(a), len(b)
+
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	// trace[d] holds the furthest x reached on each diagonal k after d
+	// edits, for k in [-d-1, d+1], so the path can be walked back.
+	var trace [][]int
+	v := []int{0, 0, 0}
+	d := 0
+search:
+	for ; ; d++ {
+		next := make([]int, 2*d+3)
+		at := func(k int) int { return v[k+d] } // v holds diagonals [-d, d] from the last round
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				x = at(k + 1)
+			} else {
+				x = at(k-1) + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			next[k+d+1] = x
+			if x >= n && y >= m {
+				trace = append(trace, next)
+				break search
+			}
+		}
+		trace = append(trace, next)
+		v = next
+	}
+
+	// walk back through the trace collecting the matching lines
+	type match struct{ x, y int }
+	var matches []match
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		// the snake starts one step on from the previous point
+		sx, sy := prevX+1, prevY // line deleted from a
+		if prevK == k+1 {
+			sx, sy = prevX, prevY+1 // line inserted from b
+		}
+		for x > sx && y > sy {
+			x--
+			y--
+			matches = append(matches, match{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		matches = append(matches, match{x, y})
+	}
+
+	// turn the gaps between matching lines into edits
+	var edits []Edit
+	ai, bi := 0, 0
+	// matches were collected from the end, so walk them backwards
+	for i := len(matches) - 1; i >= -1; i-- {
+		mx, my := n, m
+		if i >= 0 {
+			mx, my = matches[i].x, matches[i].y
+		}
+		if mx > ai || my > bi {
+			edits = append(edits, Edit{
+				Start: pre + ai,
+				End:   pre + mx,
+				Lines: b[bi:my],
+			})
+		}
+		ai, bi = mx+1, my+1
+	}
+	return edits
+}
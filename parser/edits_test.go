@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// applyEdits will apply edits to src, used to check Edits
+func applyEdits(src string, edits []Edit) string {
+	lines := splitLines(src)
+	var out strings.Builder
+	n := 0
+	for _, e := range edits {
+		out.WriteString(strings.Join(lines[n:e.Start], ""))
+		out.WriteString(strings.Join(e.Lines, ""))
+		n = e.End
+	}
+	out.WriteString(strings.Join(lines[n:], ""))
+	return out.String()
+}
+
+func TestEdits(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		dst   string
+		edits int
+	}{
+		{name: "Empty", src: "", dst: "", edits: 0},
+		{name: "Same", src: "a\nb\nc\n", dst: "a\nb\nc\n", edits: 0},
+		{name: "From Empty", src: "", dst: "a\nb\n", edits: 1},
+		{name: "To Empty", src: "a\nb\n", dst: "", edits: 1},
+		{name: "Change", src: "a\nb\nc\n", dst: "a\nB\nc\n", edits: 1},
+		{name: "Insert", src: "a\nc\n", dst: "a\nb\nc\n", edits: 1},
+		{name: "Delete", src: "a\nb\nc\n", dst: "a\nc\n", edits: 1},
+		{name: "Two Changes", src: "a\nb\nc\nd\ne\n", dst: "A\nb\nc\nd\nE\n", edits: 2},
+		{name: "Interleaved", src: "a\nb\nc\nd\ne\nf\n", dst: "b\nx\nc\ne\nf\ny\n", edits: 4},
+		{name: "No Newline", src: "a\nb", dst: "a\nb\n", edits: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+			edits := Edits([]byte(test.src), []byte(test.dst))
+			a.Len(edits, test.edits)
+			a.Equal(test.dst, applyEdits(test.src, edits))
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"os/exec"
+	"strings"
+)
+
+// embeddedPragma marks the raw string starting on the next line, or
+// after the comments following it, as holding another language, formatted
+// by the EmbeddedFormatter named after it, as in // go:fmt-embedded json
+const embeddedPragma = "go:fmt-embedded"
+
+// EmbeddedFormatter formats src, the contents of a raw string marked with
+// // go:fmt-embedded, less the indentation of its lines, which is added
+// back to the output.
+type EmbeddedFormatter func(ctx context.Context, src string) (string, error)
+
+// builtinEmbedded are the EmbeddedFormatters used unless one of the same
+// name is in Formatter.Embedded
+var builtinEmbedded = map[string]EmbeddedFormatter{
+	"json": FormatJSON,
+}
+
+// FormatJSON indents src, JSON, with tabs, or if it is on one line makes
+// it as short as it can be, so it stays on one line
+func FormatJSON(ctx context.Context, src string) (string, error) {
+	var out bytes.Buffer
+	var err error
+	if strings.Contains(strings.TrimSpace(src), "\n") {
+		err = json.Indent(&out, []byte(src), "", "\t")
+	} else {
+		err = json.Compact(&out, []byte(src))
+	}
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// EmbeddedCommand returns an EmbeddedFormatter that pipes the source to
+// command[0], run with the rest of command as its arguments, and reads
+// the output
+func EmbeddedCommand(command []string) EmbeddedFormatter {
+	return func(ctx context.Context, src string) (string, error) {
+		if len(command) == 0 {
+			return "", fmt.Errorf("no formatter program")
+		}
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(src)
+		cmd.WaitDelay = killWaitDelay
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s: %w", strings.Join(command, " "), ctx.Err())
+		}
+		if err != nil {
+			return "", &FormatterExecError{Command: strings.Join(command, " "), Stderr: stderr.String(), Err: err}
+		}
+		return stdout.String(), nil
+	}
+}
+
+// embeddedFormatter returns the EmbeddedFormatter called name, or nil
+func (f *Formatter) embeddedFormatter(name string) EmbeddedFormatter {
+	if format, ok := f.Embedded[name]; ok {
+		return format
+	}
+	return builtinEmbedded[name]
+}
+
+// formatEmbedded formats the raw strings marked with // go:fmt-embedded in
+// the formatted blocks of processed, the output of the formatter.  Names
+// with no EmbeddedFormatter, and raw strings in go:nofmt blocks, are left
+// alone.  It reports if any were changed.
+func (f *Formatter) formatEmbedded(ctx context.Context, processed []*block) (bool, error) {
+	src := joinBlocks(processed)
+	if !bytes.Contains(src, []byte(embeddedPragma)) {
+		return false, nil
+	}
+
+	// the offset in src each block starts at
+	starts := make([]int, len(processed)+1)
+	for i, b := range processed {
+		starts[i+1] = starts[i]
+		for _, l := range b.lines {
+			starts[i+1] += len(l)
+		}
+	}
+	blockAt := func(offset int) int {
+		i := 0
+		for i+1 < len(processed) && starts[i+1] <= offset {
+			i++
+		}
+		return i
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	type literal struct {
+		offset int
+		lit    string
+		format EmbeddedFormatter
+		name   string
+	}
+	var literals []literal
+	var format EmbeddedFormatter
+	var name string
+	pragmaLine := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		line := file.Line(pos)
+		if format != nil && line > pragmaLine+1 {
+			format = nil
+		}
+
+		switch {
+		case tok == token.COMMENT && strings.HasPrefix(lit, "//"):
+			fields := strings.Fields(lit[2:])
+			if len(fields) == 2 && fields[0] == embeddedPragma {
+				name = fields[1]
+				format = f.embeddedFormatter(name)
+				pragmaLine = line
+			} else if format != nil {
+				pragmaLine = line // comments can follow the pragma
+			}
+		case tok == token.STRING && strings.HasPrefix(lit, "`") && format != nil && line == pragmaLine+1:
+			if !processed[blockAt(file.Offset(pos))].formatted {
+				continue
+			}
+			literals = append(literals, literal{offset: file.Offset(pos), lit: lit, format: format, name: name})
+			format = nil
+		}
+	}
+
+	// replace the literals from the last, so the offsets hold
+	changed := false
+	for i := len(literals) - 1; i >= 0; i-- {
+		l := literals[i]
+		out, err := formatLiteral(ctx, l.lit, l.format)
+		if err != nil {
+			return false, fmt.Errorf("line %d: %s %s: %w", file.Line(file.Pos(l.offset)), embeddedPragma, l.name, err)
+		}
+		if out == l.lit {
+			continue
+		}
+
+		b := blockAt(l.offset)
+		text := string(joinBlocks(processed[b : b+1]))
+		at := l.offset - starts[b]
+		processed[b].lines = splitLines(text[:at] + out + text[at+len(l.lit):])
+		changed = true
+	}
+	return changed, nil
+}
+
+// formatLiteral returns lit, a raw string, with its contents formatted by
+// format.  The lines of the contents keep their indentation, as do a
+// newline after the opening back quote and the indentation of the closing
+// one on a line of its own.  A literal on one line is left alone if the
+// output is not on one line.
+func formatLiteral(ctx context.Context, lit string, format EmbeddedFormatter) (string, error) {
+	contents := lit[1 : len(lit)-1]
+	if !strings.Contains(contents, "\n") {
+		out, err := format(ctx, contents)
+		if err != nil {
+			return "", err
+		}
+		out = strings.TrimRight(out, "\n")
+		if strings.Contains(out, "\n") || strings.Contains(out, "`") {
+			return lit, nil
+		}
+		return "`" + out + "`", nil
+	}
+
+	// the newline after the opening back quote, and the last line if it
+	// is only the indentation of the closing back quote
+	var lead, trail string
+	body := contents
+	if strings.HasPrefix(body, "\n") {
+		lead, body = "\n", body[1:]
+	}
+	if i := strings.LastIndex(body, "\n"); i >= 0 && strings.TrimSpace(body[i+1:]) == "" {
+		trail, body = body[i:], body[:i]
+	}
+
+	// the indentation of the lines, other than a first line following
+	// the opening back quote
+	lines := strings.Split(body, "\n")
+	first := 0
+	if len(lead) == 0 {
+		first = 1
+	}
+	indent := ""
+	found := false
+	for _, l := range lines[first:] {
+		if len(strings.TrimSpace(l)) == 0 {
+			continue
+		}
+		lineIndent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+		if !found {
+			indent, found = lineIndent, true
+		}
+		for !strings.HasPrefix(lineIndent, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+	for i := first; i < len(lines); i++ {
+		lines[i] = strings.TrimPrefix(lines[i], indent)
+	}
+
+	out, err := format(ctx, strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(out, "`") {
+		return "", fmt.Errorf("output has a back quote")
+	}
+	lines = strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for i := first; i < len(lines); i++ {
+		if len(strings.TrimSpace(lines[i])) > 0 {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return "`" + lead + strings.Join(lines, "\n") + trail + "`", nil
+}
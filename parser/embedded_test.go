@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatJSON(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	out, err := FormatJSON(ctx, `{"a": 1,  "b": [1, 2]}`)
+	a.NoError(err)
+	a.Equal(`{"a":1,"b":[1,2]}`, out)
+
+	out, err = FormatJSON(ctx, "{\"a\": 1,\n\"b\": [1, 2]}\n")
+	a.NoError(err)
+	a.Equal("{\n\t\"a\": 1,\n\t\"b\": [\n\t\t1,\n\t\t2\n\t]\n}\n", out)
+
+	_, err = FormatJSON(ctx, `{"a": }`)
+	a.Error(err)
+}
+
+func TestFormatLiteral(t *testing.T) {
+	upper := func(ctx context.Context, src string) (string, error) {
+		return strings.ToUpper(src), nil
+	}
+	tests := []struct {
+		name string
+		lit  string
+		out  string
+	}{
+		{name: "one line", lit: "`select 1`", out: "`SELECT 1`"},
+		{name: "indented", lit: "`\n\t\tselect a\n\t\t  from t\n\n\t\twhere b\n\t`", out: "`\n\t\tSELECT A\n\t\t  FROM T\n\n\t\tWHERE B\n\t`"},
+		{name: "first line", lit: "`select a\n\t\tfrom t`", out: "`SELECT A\n\t\tFROM T`"},
+		{name: "mixed indent", lit: "`\n\t\tselect a\n\tfrom t\n`", out: "`\n\t\tSELECT A\n\tFROM T\n`"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := formatLiteral(context.Background(), test.lit, upper)
+			assert.NoError(t, err)
+			assert.Equal(t, test.out, out)
+		})
+	}
+
+	lines := func(ctx context.Context, src string) (string, error) {
+		return strings.ReplaceAll(src, " ", "\n"), nil
+	}
+	out, err := formatLiteral(context.Background(), "`a b`", lines)
+	assert.NoError(t, err)
+	assert.Equal(t, "`a b`", out)
+
+	quote := func(ctx context.Context, src string) (string, error) {
+		return "`" + src, nil
+	}
+	_, err = formatLiteral(context.Background(), "`a\nb`", quote)
+	assert.Error(t, err)
+}
+
+func TestFormatEmbedded(t *testing.T) {
+	src := `package a
+
+func f() {
+	// go:fmt-embedded json
+	a   := ` + "`" + `
+		{"a": 1,
+		"b": 2}
+	` + "`" + `
+	// go:fmt-embedded json
+	b := ` + "`" + `{"a":  1}` + "`" + `
+	// go:fmt-embedded sql
+	c := ` + "`" + `select 1` + "`" + `
+	// go:fmt-embedded yaml
+	d := ` + "`" + `a:   1` + "`" + `
+	// go:fmt-embedded json
+
+	e := ` + "`" + `{"a":  1}` + "`" + `
+	// go:nofmt
+	// go:fmt-embedded json
+	g := ` + "`" + `{"a":  1}` + "`" + `
+	// go:fmt
+	_, _, _, _, _, _ = a, b, c, d, e, g
+}
+`
+	out := `package a
+
+func f() {
+	// go:fmt-embedded json
+	a := ` + "`" + `
+		{
+			"a": 1,
+			"b": 2
+		}
+	` + "`" + `
+	// go:fmt-embedded json
+	b := ` + "`" + `{"a":1}` + "`" + `
+	// go:fmt-embedded sql
+	c := ` + "`" + `SELECT 1` + "`" + `
+	// go:fmt-embedded yaml
+	d := ` + "`" + `a:   1` + "`" + `
+	// go:fmt-embedded json
+
+	e := ` + "`" + `{"a":  1}` + "`" + `
+	// go:nofmt
+	// go:fmt-embedded json
+	g := ` + "`" + `{"a":  1}` + "`" + `
+	// go:fmt
+	_, _, _, _, _, _ = a, b, c, d, e, g
+}
+`
+	a := assert.New(t)
+	f := New()
+	f.Embedded = map[string]EmbeddedFormatter{"sql": EmbeddedCommand([]string{"tr", "a-z", "A-Z"})}
+
+	res, err := f.Format([]byte(src), "a.go")
+	a.NoError(err)
+	a.Equal(out, string(res.Output))
+
+	// only the lines being formatted
+	var buf strings.Builder
+	a.NoError(f.FormatLines(strings.NewReader(src), &buf, &buf, []LineRange{{Start: 10, End: 10}}))
+	a.Equal(strings.Replace(src, `{"a":  1}`, `{"a":1}`, 1), buf.String())
+
+	_, err = f.Format([]byte("package a\n\n// go:fmt-embedded json\nvar a = `{`\n"), "a.go")
+	a.EqualError(err, "a.go: line 4: go:fmt-embedded json: unexpected end of JSON input")
+
+	f.Embedded["sql"] = EmbeddedCommand([]string{"false"})
+	_, err = f.Format([]byte(src), "a.go")
+	var execErr *FormatterExecError
+	a.True(errors.As(err, &execErr))
+}
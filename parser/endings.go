@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"bytes"
+)
+
+// utf8BOM is the byte order mark some editors start UTF-8 files with
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// lineEndings is how a source ends its lines, and whether it starts with
+// a byte order mark
+type lineEndings struct {
+	crlf bool // lines end with \r\n rather than \n
+	bom  bool // the source starts with a UTF-8 byte order mark
+}
+
+// detectEndings returns the line endings of src.  If it mixes \r\n and
+// \n the most used one is its convention.
+func detectEndings(src []byte) lineEndings {
+	crlf := bytes.Count(src, []byte("\r\n"))
+	lf := bytes.Count(src, []byte("\n")) - crlf
+	return lineEndings{
+		crlf: crlf > lf,
+		bom:  bytes.HasPrefix(src, utf8BOM),
+	}
+}
+
+// normalize returns src without a byte order mark and with lines ending
+// in \n, as formatters write them.  Go drops carriage returns from raw
+// strings, so the program is the same.
+func (e lineEndings) normalize(src []byte) []byte {
+	if e.bom {
+		src = src[len(utf8BOM):]
+	}
+	if bytes.Contains(src, []byte("\r\n")) {
+		src = bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+	}
+	return src
+}
+
+// restore returns out, a normalized source, with the line endings and
+// byte order mark of e
+func (e lineEndings) restore(out []byte) []byte {
+	if e.crlf {
+		out = bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n"))
+	}
+	if e.bom {
+		out = append(append([]byte{}, utf8BOM...), out...)
+	}
+	return out
+}
+
+// endings returns the line endings to give the output of formatting src
+func (f *Formatter) endings(src []byte) lineEndings {
+	e := detectEndings(src)
+	if f.ForceLF {
+		e.crlf = false
+	}
+	return e
+}
@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectEndings(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(lineEndings{}, detectEndings([]byte("package a\n\nvar a int\n")))
+	a.Equal(lineEndings{crlf: true}, detectEndings([]byte("package a\r\n\r\nvar a int\n")))
+	a.Equal(lineEndings{}, detectEndings([]byte("package a\r\n\nvar a int\n")))
+	a.Equal(lineEndings{crlf: true, bom: true}, detectEndings([]byte("\xef\xbb\xbfpackage a\r\n")))
+
+	e := lineEndings{crlf: true, bom: true}
+	src := []byte("\xef\xbb\xbfpackage a\r\n\nvar a = `x\r\n`\r\n")
+	a.Equal("package a\n\nvar a = `x\n`\n", string(e.normalize(src)))
+	a.Equal("\xef\xbb\xbfpackage a\r\n\r\nvar a = `x\r\n`\r\n", string(e.restore(e.normalize(src))))
+}
+
+func TestFormatEndings(t *testing.T) {
+	src := "package a\n\nvar a   int\n\n// go:nofmt\nvar b   int\n// go:fmt\n"
+	out := "package a\n\nvar a int\n\n// go:nofmt\nvar b   int\n// go:fmt\n"
+	crlf := func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") }
+	bom := "\xef\xbb\xbf"
+
+	tests := []struct {
+		name    string
+		src     string
+		out     string
+		forceLF bool
+	}{
+		{name: "lf", src: src, out: out},
+		{name: "crlf", src: crlf(src), out: crlf(out)},
+		{name: "bom", src: bom + src, out: bom + out},
+		{name: "crlf bom", src: bom + crlf(src), out: bom + crlf(out)},
+		{name: "mixed", src: crlf(src) + "var c   int\n", out: crlf(out) + "var c int\r\n"},
+		{name: "force lf", src: bom + crlf(src), out: bom + out, forceLF: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+			f := New()
+			f.ForceLF = test.forceLF
+
+			res, err := f.Format([]byte(test.src), "a.go")
+			a.NoError(err)
+			a.Equal(test.out, string(res.Output))
+			a.Equal(test.src, string(res.Original))
+
+			var out, errOut bytes.Buffer
+			a.NoError(f.FormatReader(strings.NewReader(test.src), &out, &errOut))
+			a.Equal(test.out, out.String())
+
+			// the formatter is given the normalized source, not the file
+			file := filepath.Join(t.TempDir(), "a.go")
+			a.NoError(ioutil.WriteFile(file, []byte(test.src), 0644))
+			out.Reset()
+			a.NoError(f.FormatFile(file, &out, &errOut))
+			a.Equal(test.out, out.String())
+			a.Equal(test.src, string(f.SourceData()))
+
+			out.Reset()
+			a.NoError(f.FormatLines(strings.NewReader(test.src), &out, &errOut, []LineRange{{Start: 1, End: 100}}))
+			a.Equal(test.out, out.String())
+		})
+	}
+
+	merged, err := Merge([]byte(crlf(src)), []byte(out))
+	assert.NoError(t, err)
+	assert.Equal(t, crlf(out), string(merged))
+
+	// errors are still reported against the file
+	file := filepath.Join(t.TempDir(), "bad.go")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(crlf("package a\n\nvar b = \n")), 0644))
+	var buf, errOut bytes.Buffer
+	err = New().FormatFile(file, &buf, &errOut)
+	assert.EqualError(t, err, file+":3:10: expected operand, found 'EOF'")
+}
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FormatterExecError is returned when the formatter fails to run, exits
+// with an error or writes to standard error
+type FormatterExecError struct {
+	Command string // formatter command line
+	Stderr  string // standard error of the formatter
+	Err     error  // error running the formatter, nil if it only wrote to standard error
+}
+
+func (e *FormatterExecError) Error() string {
+	return fmt.Sprintf("%s: returned error%s", e.Command, errStr(e.Err))
+}
+
+func (e *FormatterExecError) Unwrap() error {
+	return e.Err
+}
+
+// SourceError is an error at a position of the source reported by the
+// formatter
+type SourceError struct {
+	File    string `json:"file"` // file name as reported, <standard input> for standard input
+	Line    int    `json:"line"`
+	Column  int    `json:"column"` // 0 if not reported
+	Message string `json:"message"`
+}
+
+func (e SourceError) Error() string {
+	pos := strconv.Itoa(e.Line)
+	if e.Column > 0 {
+		pos += ":" + strconv.Itoa(e.Column)
+	}
+	if len(e.File) > 0 {
+		pos = e.File + ":" + pos
+	}
+	return pos + ": " + e.Message
+}
+
+// SyntaxError is returned when the formatter fails because of errors in
+// the source
+type SyntaxError struct {
+	Errors []SourceError       // errors reported by the formatter, in order
+	Exec   *FormatterExecError // the formatter failure
+}
+
+func (e *SyntaxError) Error() string {
+	s := e.Errors[0].Error()
+	if len(e.Errors) > 1 {
+		s += fmt.Sprintf(" (and %d more errors)", len(e.Errors)-1)
+	}
+	return s
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Exec
+}
+
+// BlockMismatchError is returned when the formatter output does not have
+// the same go:nofmt and go:fmt blocks as the source, such as when a
+// formatter moves or removes a pragma
+type BlockMismatchError struct {
+	Original  int   // number of blocks in the source
+	Formatted int   // number of blocks in the formatter output
+	Lines     []int // pragma lines of the source around the first block that differs
+}
+
+func (e *BlockMismatchError) Error() string {
+	s := fmt.Sprintf("block mismatch: %d != %d", e.Original, e.Formatted)
+	if len(e.Lines) > 0 {
+		lines := make([]string, len(e.Lines))
+		for i, l := range e.Lines {
+			lines[i] = strconv.Itoa(l)
+		}
+		s += ", check the pragmas on line " + strings.Join(lines, ", ")
+	}
+	return s
+}
+
+// sourceErrorRE matches file:line:column: message, the column is optional
+var sourceErrorRE = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?: (.*)$`)
+
+// parseSourceErrors returns the errors with positions in the standard
+// error of a formatter
+func parseSourceErrors(stderr string) []SourceError {
+	var errs []SourceError
+	for _, line := range strings.Split(stderr, "\n") {
+		m := sourceErrorRE.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		e := SourceError{File: m[1], Message: m[4]}
+		e.Line, _ = strconv.Atoi(m[2])
+		if len(m[3]) > 0 {
+			e.Column, _ = strconv.Atoi(m[3])
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// blockMismatch describes why the blocks of processed do not match those
+// of original.  The first block whose text differs, ignoring white space,
+// is taken to be where a pragma was lost.
+func blockMismatch(original []*block, processed []*block) *BlockMismatchError {
+	e := &BlockMismatchError{Original: len(original), Formatted: len(processed)}
+
+	i := 0
+	for i < len(original) && i < len(processed) && squash(original[i].lines) == squash(processed[i].lines) {
+		i++
+	}
+	if i == len(original) {
+		return e
+	}
+
+	// line number of the first line of each block
+	starts := make([]int, len(original)+1)
+	starts[0] = 1
+	for n, b := range original {
+		starts[n+1] = starts[n] + len(b.lines)
+	}
+
+	// a go:nofmt ends the formatted block before an unformatted block,
+	// and a go:fmt starts the formatted block after one
+	if i > 0 {
+		if original[i].formatted {
+			e.Lines = append(e.Lines, starts[i])
+		} else {
+			e.Lines = append(e.Lines, starts[i]-1)
+		}
+	}
+	if i+1 < len(original) {
+		if original[i].formatted {
+			e.Lines = append(e.Lines, starts[i+1]-1)
+		} else {
+			e.Lines = append(e.Lines, starts[i+1])
+		}
+	}
+	return e
+}
+
+// squash returns lines without any white space
+func squash(lines []string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		for _, f := range strings.Fields(l) {
+			b.WriteString(f)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSourceErrors(t *testing.T) {
+	a := assert.New(t)
+
+	errs := parseSourceErrors("<standard input>:3:15: expected operand, found 'EOF'\n" +
+		"a.go:7: missing return\n" +
+		`C:\src\b.go:1:2: expected 'package'` + "\r\n" +
+		"open missing.go: no such file or directory\n")
+	a.Equal([]SourceError{
+		{File: "<standard input>", Line: 3, Column: 15, Message: "expected operand, found 'EOF'"},
+		{File: "a.go", Line: 7, Message: "missing return"},
+		{File: `C:\src\b.go`, Line: 1, Column: 2, Message: "expected 'package'"},
+	}, errs)
+	a.Equal("a.go:7: missing return", errs[1].Error())
+	a.Empty(parseSourceErrors("open missing.go: no such file or directory\n"))
+
+	synErr := &SyntaxError{Errors: errs}
+	a.Equal("<standard input>:3:15: expected operand, found 'EOF' (and 2 more errors)", synErr.Error())
+}
+
+func TestFmtFileErrors(t *testing.T) {
+	a := assert.New(t)
+	var out, errOut bytes.Buffer
+
+	f := Formatter{formatter: "gofmt %f"}
+	_, err := f.fmtFile(context.Background(), "test-files/missing.go", "", nil, &errOut)
+	var execErr *FormatterExecError
+	if a.True(errors.As(err, &execErr)) {
+		a.Equal("gofmt missing.go", execErr.Command)
+		a.Contains(execErr.Stderr, "missing.go")
+		a.Error(errors.Unwrap(err))
+	}
+
+	err = New().FormatReader(strings.NewReader("package a\nvar a = \n"), &out, &errOut)
+	var synErr *SyntaxError
+	if a.True(errors.As(err, &synErr)) {
+		a.Equal(2, synErr.Errors[0].Line)
+		a.True(errors.As(err, &execErr))
+	}
+	a.Contains(errOut.String(), "<standard input>:2:")
+}
+
+func TestBlockMismatch(t *testing.T) {
+	a := assert.New(t)
+
+	// the formatter drops the go:fmt pragma on line 6
+	src := "package a\n\n// go:nofmt\nvar a   int\n\n// go:fmt\nvar b int\n"
+	_, err := NewFormatter("grep -v go:fmt").Format([]byte(src), "a.go")
+	var mismatch *BlockMismatchError
+	if a.True(errors.As(err, &mismatch)) {
+		a.Equal(&BlockMismatchError{Original: 3, Formatted: 2, Lines: []int{3, 6}}, mismatch)
+		a.Equal("a.go: block mismatch: 3 != 2, check the pragmas on line 3, 6", err.Error())
+	}
+
+	var out, errOut bytes.Buffer
+	err = NewFormatter("grep -v go:fmt").FormatLines(strings.NewReader(src), &out, &errOut, []LineRange{{Start: 7, End: 7}})
+	a.True(errors.As(err, &mismatch))
+}
+
+func TestFormatLinesSyntaxError(t *testing.T) {
+	a := assert.New(t)
+	var out, errOut bytes.Buffer
+
+	// FormatLines adds pragmas, the error is on line 4 of the source
+	err := New().FormatLines(strings.NewReader("package a\n\nvar a   int\nvar b = \n"), &out, &errOut, []LineRange{{Start: 3, End: 3}})
+	var synErr *SyntaxError
+	if a.True(errors.As(err, &synErr)) {
+		a.Equal(4, synErr.Errors[0].Line)
+	}
+}
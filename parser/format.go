@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+)
+
+// stdinName is how gofmt names standard input in errors
+const stdinName = "<standard input>"
+
+// Result is the outcome of Format
+type Result struct {
+	Output   []byte   // formatted source
+	Original []byte   // source that was formatted
+	Changed  bool     // Output differs from Original
+	Regions  []Region // formatted and unformatted regions of Original, in order
+}
+
+// Region is a range of lines of the original source that is either
+// formatted, or left as it is between // go:nofmt and // go:fmt pragmas.
+// A // go:nofmt line ends the formatted region before it, and a // go:fmt
+// line starts the formatted region after it.
+type Region struct {
+	LineRange
+	Formatted bool `json:"formatted"`
+}
+
+// Format formats src with the default fmter, see Formatter.Format
+func Format(src []byte, filename string) (Result, error) {
+	return New().Format(src, filename)
+}
+
+// Format formats src, honoring the // go:nofmt and // go:fmt pragmas.
+// src is piped to the formatter, run in the directory of filename, whose
+// extension also gives the language, see lexerFor.  Errors in src are a
+// *SyntaxError, others may wrap a *FormatterExecError or a
+// *BlockMismatchError.  Format does not change f, so it is safe to call
+// from many goroutines.
+func (f *Formatter) Format(src []byte, filename string) (Result, error) {
+	return f.FormatContext(context.Background(), src, filename)
+}
+
+// FormatContext is Format with a context.  If ctx is done before the
+// formatter finishes, the formatter is killed and the error of ctx is
+// returned.  If filename is a Markdown file only its Go code blocks are
+// formatted, and if it is a Go template, ending in .go.tmpl, the Go
+// around its actions is, and there are no Regions.
+func (f *Formatter) FormatContext(ctx context.Context, src []byte, filename string) (Result, error) {
+	if out, ok, err := f.formatByName(ctx, src, filename); ok {
+		if err != nil {
+			return Result{}, nameErrors(err, filename)
+		}
+		return Result{
+			Output:   out,
+			Original: src,
+			Changed:  !bytes.Equal(src, out),
+		}, nil
+	}
+	res, err := f.formatSource(ctx, src, filename)
+	if err != nil {
+		return Result{}, nameErrors(err, filename)
+	}
+	return res, nil
+}
+
+// formatByName formats src by the kind of file filename is, returning
+// false if it is left to formatSource
+func (f *Formatter) formatByName(ctx context.Context, src []byte, filename string) ([]byte, bool, error) {
+	switch {
+	case isMarkdown(filename):
+		out, err := f.formatMarkdown(ctx, src, filename)
+		return out, true, err
+	case isTemplate(filename):
+		out, err := f.formatTemplate(ctx, src, filename)
+		return out, true, err
+	}
+	return nil, false, nil
+}
+
+// formatSource formats src as Go, or as the language of filename, see
+// Format.  The errors do not name the source, see nameErrors.
+func (f *Formatter) formatSource(ctx context.Context, src []byte, filename string) (Result, error) {
+	e := f.endings(src)
+	norm := e.normalize(src)
+	original, processed, err := f.formatBlocks(ctx, "", filename, norm, ioutil.Discard)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var out bytes.Buffer
+	err = f.mergeChecked(ctx, norm, e, lexerFor(filename), original, processed, &out)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Output:   out.Bytes(),
+		Original: src,
+		Changed:  !bytes.Equal(src, out.Bytes()),
+		Regions:  regions(original),
+	}, nil
+}
+
+// nameErrors names the source in err, from formatting a source piped to
+// the formatter, filename
+func nameErrors(err error, filename string) error {
+	if len(filename) == 0 {
+		filename = "<stdin>"
+	}
+	if synErr, ok := err.(*SyntaxError); ok {
+		return nameSyntaxError(synErr, filename)
+	}
+	return fmt.Errorf("%s: %w", filename, err)
+}
+
+// nameSyntaxError names the source piped to the formatter filename in the
+// errors of synErr
+func nameSyntaxError(synErr *SyntaxError, filename string) *SyntaxError {
+	for i := range synErr.Errors {
+		if synErr.Errors[i].File == stdinName {
+			synErr.Errors[i].File = filename
+		}
+	}
+	return synErr
+}
+
+// regions returns the line ranges of blocks
+func regions(blocks []*block) []Region {
+	var r []Region
+	line := 1
+	for _, b := range blocks {
+		if len(b.lines) == 0 {
+			continue
+		}
+		r = append(r, Region{
+			LineRange: LineRange{Start: line, End: line + len(b.lines) - 1},
+			Formatted: b.formatted,
+		})
+		line += len(b.lines)
+	}
+	return r
+}
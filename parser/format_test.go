@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	a := assert.New(t)
+
+	src := "package main\n\nvar a   int\n\n// go:nofmt\nvar b   int\n\n// go:fmt\nvar c   int\n"
+	res, err := Format([]byte(src), "a.go")
+	a.NoError(err)
+	a.Equal("package main\n\nvar a int\n\n// go:nofmt\nvar b   int\n\n// go:fmt\nvar c int\n", string(res.Output))
+	a.Equal(src, string(res.Original))
+	a.True(res.Changed)
+	a.Equal([]Region{
+		{LineRange: LineRange{Start: 1, End: 5}, Formatted: true},
+		{LineRange: LineRange{Start: 6, End: 7}},
+		{LineRange: LineRange{Start: 8, End: 9}, Formatted: true},
+	}, res.Regions)
+
+	res, err = Format(res.Output, "a.go")
+	a.NoError(err)
+	a.False(res.Changed)
+
+	_, err = Format([]byte("package main\n\nvar a   int =\n"), "a.go")
+	var synErr *SyntaxError
+	if a.True(errors.As(err, &synErr)) {
+		a.Equal([]SourceError{{File: "a.go", Line: 3, Column: 15, Message: "expected operand, found 'EOF'"}}, synErr.Errors)
+		a.Equal("a.go:3:15: expected operand, found 'EOF'", err.Error())
+	}
+
+	_, err = NewFormatter("false").Format([]byte(src), "")
+	var execErr *FormatterExecError
+	if a.True(errors.As(err, &execErr)) {
+		a.Equal("false", execErr.Command)
+		a.Equal("<stdin>: false: returned error (exit status 1)", err.Error())
+	}
+}
+
+func TestFormatConcurrent(t *testing.T) {
+	f := NewFormatter("gofmt")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := fmt.Sprintf("package main\n\nvar v%d   int\n// go:nofmt\nvar w%d   int\n// go:fmt\n", i, i)
+			res, err := f.Format([]byte(src), "")
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("package main\n\nvar v%d int\n\n// go:nofmt\nvar w%d   int\n// go:fmt\n", i, i), string(res.Output))
+		}(i)
+	}
+	wg.Wait()
+}
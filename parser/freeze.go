@@ -0,0 +1,299 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// freezePasses limits how often Freeze formats the source again.  The
+// pragmas it adds can change how the formatter aligns the lines around
+// them, which the next pass protects in turn, and the formatter can
+// indent the pragmas, or add blank lines around them, which the next pass
+// takes.
+const freezePasses = 10
+
+// Freeze returns src with // go:nofmt and // go:fmt pragmas around the
+// lines that the formatter would only change the spacing within, such as
+// hand aligned declarations, so they keep their layout.  Each region is
+// the smallest declaration, statement, field or composite literal element
+// holding such a line, or only the header of one holding others, such as
+// the line of an if statement.  The pragmas are placed as the formatter
+// places them, so formatting leaves the result as it is.  Other changes
+// are left for formatting.  The number of regions added is also returned.
+func (f *Formatter) Freeze(ctx context.Context, src []byte, filename string) ([]byte, int, error) {
+	e := f.endings(src)
+	cur := e.normalize(src)
+
+	total := 0
+	for pass := 0; pass < freezePasses; pass++ {
+		res, err := f.FormatContext(ctx, cur, filename)
+		if err != nil {
+			return nil, 0, err
+		}
+		if placed := placePragmas(cur, res.Output); !bytes.Equal(placed, cur) {
+			cur = placed
+			continue
+		}
+		frozen, n, err := freeze(cur, res.Output)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n == 0 {
+			break
+		}
+		cur = frozen
+		total += n
+	}
+
+	// the result must still format
+	if total > 0 {
+		_, err := f.FormatContext(ctx, cur, filename)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return e.restore(cur), total, nil
+}
+
+// freeze adds pragmas to src around the lines that out, the formatted
+// src, only changes the alignment of, returning the number of regions
+func freeze(src []byte, out []byte) ([]byte, int, error) {
+	lines := splitLines(string(src))
+	aligned := make([]bool, len(lines))
+	found := false
+	for _, e := range Edits(src, out) {
+		// pair the lines of the edit with the same code, as an edit can
+		// also add or remove lines, such as blank lines near pragmas
+		next := 0
+		for i := e.Start; i < e.End; i++ {
+			for j := next; j < len(e.Lines); j++ {
+				if strings.Map(noSpace, lines[i]) == strings.Map(noSpace, e.Lines[j]) {
+					if alignmentOnly(lines[i], e.Lines[j]) {
+						aligned[i] = true
+						found = true
+					}
+					next = j + 1
+					break
+				}
+			}
+		}
+	}
+	if !found {
+		return src, 0, nil
+	}
+
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, "", src, goparser.ParseComments)
+	if err != nil {
+		return nil, 0, err
+	}
+	spans := freezeSpans(fset, file)
+
+	protect := make([]bool, len(lines))
+	for i := range lines {
+		if !aligned[i] {
+			continue
+		}
+		start, end := enclosingSpan(spans, i+1)
+		for l := start; l <= end; l++ {
+			protect[l-1] = true
+		}
+	}
+
+	// the state at the start of each line, pragmas can only be added
+	// between lines of code, and not around existing pragmas
+	startState := make([]codeState, len(lines)+1)
+	pragma := make([]bool, len(lines))
+	curState := Code
+	for i, line := range lines {
+		startState[i] = curState
+		switch newState := goLexer.parseLine(line, curState); newState {
+		case NoFmt, Fmt:
+			curState = Code
+			pragma[i] = true
+		default:
+			curState = newState
+		}
+	}
+	startState[len(lines)] = curState
+
+	// grow the regions over the raw strings and block comments they start
+	// or end in
+	for i := len(lines) - 1; i > 0; i-- {
+		if protect[i] && startState[i] != Code {
+			protect[i-1] = true
+		}
+	}
+	for i := 0; i+1 < len(lines); i++ {
+		if protect[i] && startState[i+1] != Code {
+			protect[i+1] = true
+		}
+	}
+
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(lines); {
+		if !protect[i] {
+			b.WriteString(lines[i])
+			i++
+			continue
+		}
+		end := i
+		ok := true
+		for end < len(lines) && protect[end] {
+			ok = ok && !pragma[end]
+			end++
+		}
+		ok = ok && startState[i] == Code && startState[end] == Code
+
+		// placePragmas takes the indent of the formatter in the next
+		// pass, if it differs
+		indent := lines[i][:len(lines[i])-len(strings.TrimLeftFunc(lines[i], unicode.IsSpace))]
+		if ok {
+			b.WriteString(indent + "// go:nofmt\n")
+			n++
+		}
+		for _, l := range lines[i:end] {
+			b.WriteString(l)
+		}
+		if ok {
+			if !strings.HasSuffix(lines[end-1], "\n") {
+				b.WriteString("\n")
+			}
+			b.WriteString(indent + "// go:fmt\n")
+		}
+		i = end
+	}
+	return []byte(b.String()), n, nil
+}
+
+// alignmentOnly reports if the formatter changing a to b only changes the
+// spacing within the line, where a is aligned with runs of spaces or tabs
+func alignmentOnly(a, b string) bool {
+	if a == b {
+		return false
+	}
+	code := strings.TrimSpace(a)
+	indentA := a[:strings.Index(a, code)]
+	indentB := b[:len(b)-len(strings.TrimLeftFunc(b, unicode.IsSpace))]
+	if indentA != indentB {
+		return false
+	}
+	if !strings.Contains(code, "  ") && !strings.Contains(code, "\t") {
+		return false
+	}
+	return strings.Map(noSpace, a) == strings.Map(noSpace, b)
+}
+
+// noSpace drops white space from a string with strings.Map
+func noSpace(r rune) rune {
+	if unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
+
+// placePragmas returns src with the pragmas, and the blank lines next to
+// them, as out, the formatted src, has them.  The formatter indents a
+// pragma before the } of a block as the statements of the block, and
+// puts a blank line between a declaration and a pragma before the next.
+func placePragmas(src []byte, out []byte) []byte {
+	lines := splitLines(string(src))
+	pragma := func(l string) bool {
+		state := goLexer.parseLine(l, Code)
+		return state == NoFmt || state == Fmt
+	}
+	// the lines other than blank lines, without their indent
+	code := func(ls []string) []string {
+		var c []string
+		for _, l := range ls {
+			if l = strings.TrimSpace(l); len(l) > 0 {
+				c = append(c, l)
+			}
+		}
+		return c
+	}
+
+	var b strings.Builder
+	at := 0
+	for _, e := range Edits(src, out) {
+		from, to := code(lines[e.Start:e.End]), code(e.Lines)
+		ok := len(from) == len(to)
+		for i := 0; ok && i < len(from); i++ {
+			ok = from[i] == to[i] && pragma(from[i])
+		}
+		if ok && len(from) == 0 {
+			// only blank lines, which must be next to a pragma
+			ok = (e.Start > 0 && pragma(lines[e.Start-1])) || (e.End < len(lines) && pragma(lines[e.End]))
+		}
+		if !ok {
+			continue
+		}
+		b.WriteString(strings.Join(lines[at:e.Start], ""))
+		b.WriteString(strings.Join(e.Lines, ""))
+		at = e.End
+	}
+	b.WriteString(strings.Join(lines[at:], ""))
+	return []byte(b.String())
+}
+
+// span is the lines of a node that Freeze can protect
+type span struct {
+	start, end int
+}
+
+// freezeSpans returns the lines of the declarations, statements, specs,
+// fields and composite literal elements of file
+func freezeSpans(fset *token.FileSet, file *ast.File) []*span {
+	var spans []*span
+	add := func(n ast.Node) {
+		spans = append(spans, &span{start: fset.Position(n.Pos()).Line, end: fset.Position(n.End()).Line})
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+		case ast.Stmt, ast.Decl, ast.Spec, *ast.Field:
+			add(n)
+		case *ast.CompositeLit:
+			for _, elt := range n.Elts {
+				add(elt)
+			}
+		}
+		return true
+	})
+	return spans
+}
+
+// enclosingSpan returns the lines of the smallest span holding line, or
+// only the line if there is none.  If the span holds others, only its
+// lines between them holding line are returned, such as the header of an
+// if statement, or the } else { between its blocks.
+func enclosingSpan(spans []*span, line int) (int, int) {
+	var best *span
+	for _, s := range spans {
+		if s.start <= line && line <= s.end && (best == nil || s.end-s.start < best.end-best.start) {
+			best = s
+		}
+	}
+	if best == nil {
+		return line, line
+	}
+	start, end := best.start, best.end
+	for _, s := range spans {
+		if s.start < best.start || s.end > best.end || (s.start == best.start && s.end == best.end) {
+			continue
+		}
+		if s.end < line && s.end >= start {
+			start = s.end + 1
+		}
+		if s.start > line && s.start <= end {
+			end = s.start - 1
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,267 @@
+package parser
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeze(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		out  string
+		n    int
+	}{
+		{
+			name: "formatted",
+			src:  "package a\n\nvar a int\n",
+			out:  "package a\n\nvar a int\n",
+		},
+		{
+			name: "not aligned",
+			src:  "package a\n\nfunc f() {\n\tx:=1\n\t_ = x\n}\n",
+			out:  "package a\n\nfunc f() {\n\tx:=1\n\t_ = x\n}\n",
+		},
+		{
+			name: "statements",
+			src: `package a
+
+func f() {
+	a   := 1
+	bcd := 2
+	x:=3
+	_, _, _ = a, bcd, x
+}
+`,
+			out: `package a
+
+func f() {
+	// go:nofmt
+	a   := 1
+	// go:fmt
+	bcd := 2
+	x:=3
+	_, _, _ = a, bcd, x
+}
+`,
+			n: 1,
+		},
+		{
+			name: "table",
+			src: `package a
+
+var table = []struct {
+	name string
+	n    int
+}{
+	{"a",     1},
+	{"bcd",   22},
+	{
+		"e",  3,
+	},
+}
+`,
+			out: `package a
+
+var table = []struct {
+	name string
+	n    int
+}{
+	// go:nofmt
+	{"a",     1},
+	{"bcd",   22},
+	// go:fmt
+	{
+		// go:nofmt
+		"e",  3,
+		// go:fmt
+	},
+}
+`,
+			n: 2,
+		},
+		{
+			name: "multi-line statement",
+			src: `package a
+
+func f(a, b int) {
+	f(a,  b)
+	if a  > b {
+		f(1,
+			2,  3)
+	}
+}
+`,
+			out: `package a
+
+func f(a, b int) {
+	// go:nofmt
+	f(a,  b)
+	if a  > b {
+		f(1,
+			2,  3)
+		// go:fmt
+	}
+}
+`,
+			n: 1,
+		},
+		{
+			name: "else if",
+			src: `package a
+
+func f(a, b int) {
+	if a > b {
+		f(a, b)
+	} else if a  == b {
+		f(b, a)
+	}
+}
+`,
+			out: `package a
+
+func f(a, b int) {
+	if a > b {
+		f(a, b)
+		// go:nofmt
+	} else if a  == b {
+		// go:fmt
+		f(b, a)
+	}
+}
+`,
+			n: 1,
+		},
+		{
+			name: "last case",
+			src: `package a
+
+func f(a, b int) {
+	switch {
+	case a > b:
+		f(a,  b)
+	}
+}
+`,
+			out: `package a
+
+func f(a, b int) {
+	switch {
+	case a > b:
+		// go:nofmt
+		f(a,  b)
+		// go:fmt
+	}
+}
+`,
+			n: 1,
+		},
+		{
+			name: "after a func",
+			src: `package a
+
+func f() {
+}
+func g(a,  b int) {
+}
+`,
+			out: `package a
+
+func f() {
+}
+
+// go:nofmt
+func g(a,  b int) {
+	// go:fmt
+}
+`,
+			n: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+			f := New()
+
+			out, n, err := f.Freeze(context.Background(), []byte(test.src), "a.go")
+			a.NoError(err)
+			a.Equal(test.out, string(out))
+			a.Equal(test.n, n)
+
+			// formatting keeps the frozen lines
+			res, err := f.Format(out, "a.go")
+			a.NoError(err)
+			for _, line := range strings.Split(test.out, "\n") {
+				if strings.Contains(line, "  ") {
+					a.Contains(string(res.Output), line)
+				}
+			}
+		})
+	}
+}
+
+func TestFreezeStable(t *testing.T) {
+	// the Go files of the repository, with runs of spaces added after
+	// some of the commas and assignments, so they are formatted but for
+	// their alignment
+	files, err := filepath.Glob("*.go")
+	assert.NoError(t, err)
+	more, err := filepath.Glob("../*.go")
+	assert.NoError(t, err)
+	files = append(files, more...)
+	spaced := regexp.MustCompile(`(,|=|:=|==) `)
+
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			a := assert.New(t)
+			f := New()
+
+			b, err := ioutil.ReadFile(file)
+			a.NoError(err)
+			lines := strings.SplitAfter(string(b), "\n")
+			for i, l := range lines {
+				if i%4 == 0 && !strings.HasPrefix(strings.TrimSpace(l), "//") {
+					n := 0
+					lines[i] = spaced.ReplaceAllStringFunc(l, func(s string) string {
+						if n++; n > 1 {
+							return s
+						}
+						return s + " "
+					})
+				}
+			}
+			src := strings.Join(lines, "")
+
+			out, _, err := f.Freeze(context.Background(), []byte(src), file)
+			a.NoError(err)
+
+			// the frozen source is formatted
+			res, err := f.Format(out, file)
+			a.NoError(err)
+			a.Equal(string(out), string(res.Output))
+
+			// and only pragmas and blank lines are added
+			code := func(s string) []string {
+				var c []string
+				for _, l := range strings.Split(s, "\n") {
+					if code := strings.TrimSpace(l); len(code) > 0 && code != "// go:nofmt" && code != "// go:fmt" {
+						c = append(c, l)
+					}
+				}
+				return c
+			}
+			a.Equal(code(src), code(string(out)))
+		})
+	}
+}
+
+func TestFreezeError(t *testing.T) {
+	_, _, err := New().Freeze(context.Background(), []byte("package a\n\nvar a = \n"), "a.go")
+	assert.Error(t, err)
+}
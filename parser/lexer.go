@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// lexer finds the comments and strings of the lines of a language, as
+// far as is needed to find the pragmas.  In a language other than Go the
+// pragmas are written as its line comments, such as # go:nofmt.
+type lexer struct {
+	comment string                  // starts a line comment
+	table   map[lineState]nextState // state transitions, as stateTable
+}
+
+var (
+	// goLexer is the lexer of Go, used for files of unknown languages and
+	// for standard input
+	goLexer = &lexer{comment: "//", table: stateTable}
+
+	// cLexer is the lexer of languages with // and /* */ comments, and "
+	// and ' strings and characters on one line, such as C, Java and
+	// protocol buffers
+	cLexer = &lexer{
+		comment: "//",
+		table: map[lineState]nextState{
+			Other: nextState{
+				found: runeState{
+					'/':  FoundSlash,
+					'"':  InQuote,
+					'\'': InTick,
+				},
+			},
+			Indent: nextState{
+				found: runeState{
+					' ':  Indent,
+					'\t': Indent,
+					'/':  BeginSlash,
+					'"':  InQuote,
+					'\'': InTick,
+				},
+			},
+			BeginSlash: nextState{
+				found: runeState{
+					'/': BeginLineComment, // this is an end state
+					'*': InBlockComment,
+				},
+			},
+			FoundSlash: nextState{
+				found: runeState{
+					'/': LineComment, // this is an end state
+					'*': InBlockComment,
+				},
+			},
+			FoundStar: nextState{
+				found: runeState{
+					'/': EndComment,
+				},
+				notFound: InBlockComment,
+			},
+			InBlockComment: nextState{
+				found: runeState{
+					'*': FoundStar,
+				},
+				notFound: InBlockComment,
+			},
+			InQuote: nextState{
+				found: runeState{
+					'"': FoundQuote, // look for \
+				},
+				notFound: InQuote,
+			},
+			InTick: nextState{
+				found: runeState{
+					'\'': FoundTick, // look for \
+				},
+				notFound: InTick,
+			},
+		},
+	}
+
+	// hashLexer is the lexer of languages with # line comments, and " and
+	// ' strings on one line, such as shell scripts, Python and YAML
+	hashLexer = &lexer{
+		comment: "#",
+		table: map[lineState]nextState{
+			Other: nextState{
+				found: runeState{
+					'#':  LineComment, // this is an end state
+					'"':  InQuote,
+					'\'': InTick,
+				},
+			},
+			Indent: nextState{
+				found: runeState{
+					' ':  Indent,
+					'\t': Indent,
+					'#':  BeginLineComment, // this is an end state
+					'"':  InQuote,
+					'\'': InTick,
+				},
+			},
+			InQuote: nextState{
+				found: runeState{
+					'"': FoundQuote, // look for \
+				},
+				notFound: InQuote,
+			},
+			InTick: nextState{
+				found: runeState{
+					'\'': FoundTick, // look for \
+				},
+				notFound: InTick,
+			},
+		},
+	}
+)
+
+// lexers are the lexers of languages other than Go, by file extension.
+// The pragmas of C, C++, C#, Java and protocol buffers are // comments,
+// and those of shell scripts, Python, Ruby, Perl, YAML and TOML are #
+// comments, such as # go:nofmt.
+var lexers = map[string]*lexer{
+	".c":     cLexer,
+	".h":     cLexer,
+	".cc":    cLexer,
+	".cpp":   cLexer,
+	".cxx":   cLexer,
+	".hh":    cLexer,
+	".hpp":   cLexer,
+	".hxx":   cLexer,
+	".cs":    cLexer,
+	".java":  cLexer,
+	".proto": cLexer,
+	".sh":    hashLexer,
+	".bash":  hashLexer,
+	".zsh":   hashLexer,
+	".py":    hashLexer,
+	".rb":    hashLexer,
+	".pl":    hashLexer,
+	".yaml":  hashLexer,
+	".yml":   hashLexer,
+	".toml":  hashLexer,
+}
+
+// lexerFor returns the lexer of the language of filename, by its
+// extension, or goLexer.  Only Go is checked to be the same program after
+// formatting, and has embedded languages.
+func lexerFor(filename string) *lexer {
+	if l, ok := lexers[strings.ToLower(filepath.Ext(filename))]; ok {
+		return l
+	}
+	return goLexer
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexerFor(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(goLexer, lexerFor("a.go"))
+	a.Equal(goLexer, lexerFor(""))
+	a.Equal(goLexer, lexerFor("a.go.tmpl"))
+	a.Equal(cLexer, lexerFor("dir/a.proto"))
+	a.Equal(cLexer, lexerFor("A.C"))
+	a.Equal(hashLexer, lexerFor("build.sh"))
+}
+
+func TestLexerParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		lx   *lexer
+		in   codeState
+		out  codeState
+		line string
+	}{
+		{name: "C NoFmt", lx: cLexer, in: Code, out: NoFmt, line: "  // go:nofmt\n"},
+		{name: "C block comment start", lx: cLexer, in: Code, out: BlockComment, line: "int a; /* a `"},
+		{name: "C block comment end", lx: cLexer, in: BlockComment, out: Code, line: " */ int b;"},
+		{name: "C no back tick", lx: cLexer, in: Code, out: Code, line: "char *s = \"`\";"},
+		{name: "Hash NoFmt", lx: hashLexer, in: Code, out: NoFmt, line: "\t# go:nofmt\n"},
+		{name: "Hash Fmt", lx: hashLexer, in: Code, out: Fmt, line: "#go:fmt"},
+		{name: "Hash comment on a line", lx: hashLexer, in: Code, out: Code, line: "a=1 # go:nofmt"},
+		{name: "Hash slashes", lx: hashLexer, in: Code, out: Code, line: "// go:nofmt"},
+		{name: "Hash no block comment", lx: hashLexer, in: Code, out: Code, line: "ls /* # a"},
+		{name: "Hash quote first", lx: hashLexer, in: Code, out: Code, line: `  "# go:nofmt" a`},
+		{name: "C quote first", lx: cLexer, in: Code, out: Code, line: `  "/*", a,`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.out, test.lx.parseLine(test.line, test.in))
+		})
+	}
+}
+
+func TestFormatLexers(t *testing.T) {
+	a := assert.New(t)
+
+	// squeezes spaces, which would not be the same program in Go
+	f := NewFormatterArgs([]string{"sed", "s/  */ /g"})
+
+	res, err := f.Format([]byte("a=1  # one\n# go:nofmt\nb=2    # two\n# go:fmt\nc=3  # three\n"), "a.sh")
+	a.NoError(err)
+	a.Equal("a=1 # one\n# go:nofmt\nb=2    # two\n# go:fmt\nc=3 # three\n", string(res.Output))
+	a.Equal([]Region{
+		{LineRange: LineRange{Start: 1, End: 2}, Formatted: true},
+		{LineRange: LineRange{Start: 3, End: 3}},
+		{LineRange: LineRange{Start: 4, End: 5}, Formatted: true},
+	}, res.Regions)
+
+	res, err = f.Format([]byte("int  a;\n// go:nofmt\nint  b;\n// go:fmt\nint  c;\n"), "a.c")
+	a.NoError(err)
+	a.Equal("int a;\n// go:nofmt\nint  b;\n// go:fmt\nint c;\n", string(res.Output))
+
+	// the pragmas added around the other lines are # comments
+	f = NewFormatterArgs([]string{"sed", "s/  */ /g"})
+	f.Filename = "a.sh"
+	var out bytes.Buffer
+	err = f.FormatLines(strings.NewReader("a=1  # one\nb=2  # two\n"), &out, ioutil.Discard, []LineRange{{Start: 1, End: 1}})
+	a.NoError(err)
+	a.Equal("a=1 # one\nb=2  # two\n", out.String())
+}
@@ -0,0 +1,226 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LineRange is a range of lines to be formatted.  Lines start at 1 and
+// End is included in the range.
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ParseLineRanges parses a comma separated list of line ranges, such as
+// "10:40,88:90".  A single line can be given without the colon.
+func ParseLineRanges(s string) ([]LineRange, error) {
+	var ranges []LineRange
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+		startEnd := strings.SplitN(r, ":", 2)
+		if len(startEnd) == 1 {
+			startEnd = append(startEnd, startEnd[0])
+		}
+		start, err := strconv.Atoi(startEnd[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad line range %q", r)
+		}
+		end, err := strconv.Atoi(startEnd[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad line range %q", r)
+		}
+		if start < 1 || end < start {
+			return nil, fmt.Errorf("bad line range %q", r)
+		}
+		ranges = append(ranges, LineRange{Start: start, End: end})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no line ranges in %q", s)
+	}
+	return ranges, nil
+}
+
+// FormatLines will write fmted output from reader to the out io.Writer,
+// only formatting the lines in ranges.  All other lines are treated as if
+// they were between // go:nofmt and // go:fmt pragmas.  Lines in a
+// go:nofmt block are not formatted even when they are in ranges.
+// As with FormatReader error text from the fmter is written to errOut.
+func (f *Formatter) FormatLines(in io.Reader, out io.Writer, errOut io.Writer, ranges []LineRange) error {
+	return f.FormatLinesContext(context.Background(), in, out, errOut, ranges)
+}
+
+// FormatLinesContext is FormatLines with a context.  If ctx is done before
+// the formatter finishes, the formatter is killed and the error of ctx is
+// returned.
+func (f *Formatter) FormatLinesContext(ctx context.Context, in io.Reader, out io.Writer, errOut io.Writer, ranges []LineRange) error {
+	f.file = ""
+	f.srcData.Reset()
+	_, err := io.Copy(&f.srcData, in)
+	if err != nil {
+		return err
+	}
+
+	// wrap the lines outside of ranges in pragmas, the result is then
+	// formatted as usual, and the added pragmas removed when merging
+	e := f.endings(f.srcData.Bytes())
+	norm := e.normalize(f.srcData.Bytes())
+	lines := splitLines(string(norm))
+	lx := lexerFor(f.Filename)
+	marked, origin, keep := protectLines(lines, ranges, lx)
+
+	f.original, _ = readFile(bufio.NewReader(bytes.NewReader(marked)), lx)
+	n := 0
+	for i, b := range f.original {
+		if b.formatted && len(b.lines) > 0 {
+			first, last := origin[n], origin[n+len(b.lines)-1]
+			b.keepFirst = first >= 0 && keep[first]
+			b.keepLast = last >= 0 && keep[last]
+		}
+		if !b.formatted {
+			b.synthetic = i > 0 && origin[n-1] < 0
+			for l := range b.lines {
+				if o := origin[n+l]; o >= 0 && keep[o] {
+					b.lines[l] = lines[o] // put back a hidden go:fmt
+				}
+			}
+		}
+		n += len(b.lines)
+	}
+
+	formatted, err := f.fmtFile(ctx, "", f.Filename, marked, errOut)
+	if err != nil {
+		// report the lines of the source rather than those of marked
+		if synErr, ok := err.(*SyntaxError); ok {
+			for i := range synErr.Errors {
+				synErr.Errors[i].Line = originLine(origin, synErr.Errors[i].Line)
+			}
+		}
+		return err
+	}
+	f.processed, _ = readFile(bufio.NewReader(formatted), lx)
+
+	err = f.mergeChecked(ctx, norm, e, lx, f.original, f.processed, out)
+	if mismatch, ok := err.(*BlockMismatchError); ok {
+		var lines []int
+		for _, l := range mismatch.Lines {
+			if origin[l-1] >= 0 {
+				lines = append(lines, origin[l-1]+1)
+			}
+		}
+		mismatch.Lines = lines
+	}
+	return err
+}
+
+// originLine returns the line of the source that line of the marked
+// source came from, or the line before for an added pragma
+func originLine(origin []int, line int) int {
+	if line > len(origin) && len(origin) > 0 {
+		// past the end, such as an error at EOF
+		return originLine(origin, len(origin)) + line - len(origin)
+	}
+	for l := line - 1; l >= 0; l-- {
+		if origin[l] >= 0 {
+			return origin[l] + 1
+		}
+	}
+	return line
+}
+
+// protectLines will add go:nofmt and go:fmt pragmas around the lines that
+// are not in ranges.  It returns the new source, the line each line of the
+// new source came from, or -1 for an added pragma, and the pragma lines
+// which must be kept as they are.  The pragmas are line comments of lx.
+func protectLines(lines []string, ranges []LineRange, lx *lexer) ([]byte, []int, []bool) {
+	selected := make([]bool, len(lines))
+	for _, r := range ranges {
+		for l := r.Start; l <= r.End && l <= len(lines); l++ {
+			selected[l-1] = true
+		}
+	}
+
+	// find the state at the start of each line, the pragmas and the
+	// lines already in a go:nofmt block
+	startState := make([]codeState, len(lines))
+	pragma := make([]bool, len(lines))
+	hidden := make([]bool, len(lines))
+	protect := make([]bool, len(lines))
+	curState := Code
+	formatted := true
+	for i, line := range lines {
+		startState[i] = curState
+		switch newState := lx.parseLine(line, curState); newState {
+		case NoFmt:
+			curState = Code
+			pragma[i] = true
+			formatted = false
+		case Fmt:
+			curState = Code
+			if formatted {
+				// a go:fmt that does nothing can be protected like any other
+				// line, as long as it is hidden from readFile
+				protect[i] = !selected[i]
+				hidden[i] = protect[i]
+			} else {
+				pragma[i] = true
+			}
+			formatted = true
+		default:
+			curState = newState
+			// lines in a go:nofmt block are already protected
+			protect[i] = formatted && !selected[i]
+		}
+	}
+
+	// a pragma can only be added before a line that starts in code, not in
+	// the middle of a block comment or back tick string, so grow the
+	// protected lines until they start and end on code
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < len(lines); i++ {
+			if protect[i] == protect[i-1] || startState[i] == Code {
+				continue
+			}
+			if protect[i] && !pragma[i-1] {
+				protect[i-1] = true
+				changed = true
+			} else if protect[i-1] && !pragma[i] {
+				protect[i] = true
+				changed = true
+			}
+		}
+	}
+
+	var marked bytes.Buffer
+	origin := make([]int, 0, len(lines)+8)
+	for i, line := range lines {
+		if protect[i] && (i == 0 || !protect[i-1]) {
+			marked.WriteString(lx.comment + " go:nofmt\n")
+			origin = append(origin, -1)
+		}
+		if !protect[i] && i > 0 && protect[i-1] {
+			marked.WriteString(lx.comment + " go:fmt\n")
+			origin = append(origin, -1)
+		}
+		if hidden[i] {
+			marked.WriteString(lx.comment)
+		}
+		marked.WriteString(line)
+		origin = append(origin, i)
+	}
+
+	keep := make([]bool, len(lines))
+	for i := range lines {
+		keep[i] = (pragma[i] && !selected[i]) || hidden[i]
+	}
+	return marked.Bytes(), origin, keep
+}
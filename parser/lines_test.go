@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLineRanges(t *testing.T) {
+	tests := []struct {
+		in     string
+		ranges []LineRange
+		error  bool
+	}{
+		{in: "10:40,88:90", ranges: []LineRange{{10, 40}, {88, 90}}},
+		{in: "7", ranges: []LineRange{{7, 7}}},
+		{in: " 1:2 , 5 ", ranges: []LineRange{{1, 2}, {5, 5}}},
+		{in: "", error: true},
+		{in: "a:b", error: true},
+		{in: "1:b", error: true},
+		{in: "0:3", error: true},
+		{in: "5:3", error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ranges, err := ParseLineRanges(test.in)
+			assert.Equal(t, test.error, err != nil)
+			assert.Equal(t, test.ranges, ranges)
+		})
+	}
+}
+
+func TestFormatLines(t *testing.T) {
+	src := strings.Join([]string{
+		"package main",           // 1
+		"",                       // 2
+		"func main() {",          // 3
+		"  var a   int",          // 4
+		"  var b   int",          // 5
+		"  // go:nofmt",          // 6
+		"  var c   int",          // 7
+		"  // go:fmt",            // 8
+		"  s := `raw",            // 9
+		"string`",                // 10
+		"  var d   int",          // 11
+		"  /* block",             // 12
+		"  comment */ var e int", // 13
+		"}",                      // 14
+		"",
+	}, "\n")
+
+	tests := []struct {
+		name   string
+		ranges []LineRange
+		out    []string // expected lines, from 3 to 13
+	}{
+		{
+			name:   "Nothing",
+			ranges: []LineRange{{20, 30}},
+			out:    []string{"func main() {", "  var a   int", "  var b   int", "  // go:nofmt", "  var c   int", "  // go:fmt", "  s := `raw", "string`", "  var d   int", "  /* block", "  comment */ var e int"},
+		},
+		{
+			name:   "Everything",
+			ranges: []LineRange{{1, 14}},
+			out:    []string{"func main() {", "\tvar a int", "\tvar b int", "\t// go:nofmt", "  var c   int", "\t// go:fmt", "\ts := `raw", "string`", "\tvar d int", "\t/* block", "\tcomment */var e int"},
+		},
+		{
+			name:   "One Line",
+			ranges: []LineRange{{5, 5}},
+			out:    []string{"func main() {", "  var a   int", "\tvar b int", "  // go:nofmt", "  var c   int", "  // go:fmt", "  s := `raw", "string`", "  var d   int", "  /* block", "  comment */ var e int"},
+		},
+		{
+			name:   "Pragma and nofmt",
+			ranges: []LineRange{{6, 8}},
+			out:    []string{"func main() {", "  var a   int", "  var b   int", "\t// go:nofmt", "  var c   int", "\t// go:fmt", "  s := `raw", "string`", "  var d   int", "  /* block", "  comment */ var e int"},
+		},
+		{
+			name:   "Inside Back Tick",
+			ranges: []LineRange{{10, 11}},
+			out:    []string{"func main() {", "  var a   int", "  var b   int", "  // go:nofmt", "  var c   int", "  // go:fmt", "  s := `raw", "string`", "\tvar d int", "  /* block", "  comment */ var e int"},
+		},
+		{
+			name:   "Inside Block Comment",
+			ranges: []LineRange{{13, 13}},
+			out:    []string{"func main() {", "  var a   int", "  var b   int", "  // go:nofmt", "  var c   int", "  // go:fmt", "  s := `raw", "string`", "  var d   int", "  /* block", "  comment */ var e int"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+
+			f := NewFormatter("gofmt")
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			err := f.FormatLines(strings.NewReader(src), stdout, stderr, test.ranges)
+			a.NoError(err)
+			a.Empty(stderr.String())
+
+			lines := strings.Split(stdout.String(), "\n")
+			a.Equal(test.out, lines[2:13])
+			a.Equal(src, string(f.SourceData()))
+		})
+	}
+}
+
+func TestFormatLinesUnusedPragma(t *testing.T) {
+	a := assert.New(t)
+
+	src := "package main\n\nfunc main() {\n  // go:fmt\n  var a   int\n}\n"
+
+	f := NewFormatter("gofmt")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err := f.FormatLines(strings.NewReader(src), stdout, stderr, []LineRange{{5, 5}})
+	a.NoError(err)
+	a.Equal("package main\n\nfunc main() {\n  // go:fmt\n\tvar a int\n}\n", stdout.String())
+}
+
+func TestFormatLinesOutside(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		lines LineRange
+		out   []string // the formatted lines of the range
+	}{
+		{name: "Package clause", src: "package a\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println()\n}\n", lines: LineRange{1, 1}, out: []string{"package a\n"}},
+		{name: "Closing brace", src: "package a\n\nfunc a() {\n}\n\nfunc b() {\n}\n", lines: LineRange{4, 4}, out: []string{"}\n"}},
+		{name: "Opening brace", src: "package a\n\nfunc a() {\n}\n\nfunc b()   {\n}\n", lines: LineRange{6, 6}, out: []string{"func b() {\n"}},
+		{name: "Last of vars", src: "package a\n\nvar x = 1\nvar y =   2\nvar z = 3\n", lines: LineRange{5, 5}, out: []string{"var z = 3\n"}},
+		{name: "First of vars", src: "package a\n\nvar x =  1\nvar y =   2\nvar z = 3\n", lines: LineRange{3, 3}, out: []string{"var x = 1\n"}},
+		{name: "After pragmas", src: "package a\n\nfunc main() {\n\t// go:nofmt\n\tvar a   int\n\t// go:fmt\n\tvar b   int\n\tvar c   int\n}\n", lines: LineRange{6, 7}, out: []string{"\t// go:fmt\n", "\tvar b int\n"}},
+		{name: "Before pragmas", src: "package a\n\nvar b   int\n// go:nofmt\nvar a   int\n// go:fmt\nvar c   int\n", lines: LineRange{3, 3}, out: []string{"var b int\n"}},
+		{name: "Top level after pragmas", src: "package a\n\n// go:nofmt\nvar a   int\n// go:fmt\nvar c   int\nvar d   int\n", lines: LineRange{5, 6}, out: []string{"// go:fmt\n", "var c int\n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+
+			var out bytes.Buffer
+			err := NewFormatter("gofmt").FormatLines(strings.NewReader(test.src), &out, ioutil.Discard, []LineRange{test.lines})
+			a.NoError(err)
+
+			// every line outside of the range is as it was
+			src := splitLines(test.src)
+			want := append(append(append([]string{}, src[:test.lines.Start-1]...), test.out...), src[test.lines.End:]...)
+			a.Equal(want, splitLines(out.String()))
+		})
+	}
+}
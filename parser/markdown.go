@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	goparser "go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// isMarkdown reports if name is a Markdown file, of which only the Go
+// code blocks are formatted
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// fence is a fenced code block of a Markdown file
+type fence struct {
+	start, end int    // lines of the code, from 0, end is not included
+	indent     string // indentation of the opening fence, taken off the code
+	goCode     bool   // the info string is go or golang
+}
+
+// findFences returns the ``` and ~~~ fenced code blocks of lines that are
+// closed, as CommonMark does, except that the indentation of the opening
+// fence is not limited, so blocks in lists are found
+func findFences(lines []string) []fence {
+	var fences []fence
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\n")
+		code := strings.TrimLeft(line, " \t")
+		if !strings.HasPrefix(code, "```") && !strings.HasPrefix(code, "~~~") {
+			continue
+		}
+		marker := code[:len(code)-len(strings.TrimLeft(code, code[:1]))]
+		info := strings.Fields(code[len(marker):])
+		if marker[0] == '`' && strings.Contains(code[len(marker):], "`") {
+			continue // not a fence, but inline code
+		}
+
+		f := fence{start: i + 1, indent: line[:len(line)-len(code)]}
+		if len(info) > 0 {
+			lang := strings.ToLower(info[0])
+			f.goCode = lang == "go" || lang == "golang"
+		}
+		for j := i + 1; j < len(lines); j++ {
+			closing := strings.TrimSpace(lines[j])
+			if strings.HasPrefix(closing, marker) && strings.Trim(closing, marker[:1]) == "" {
+				f.end = j
+				break
+			}
+		}
+		if f.end == 0 {
+			break // the rest of the file is in the block
+		}
+		fences = append(fences, f)
+		i = f.end
+	}
+	return fences
+}
+
+// fenceWrapper makes the code of a fenced block a Go file
+type fenceWrapper struct {
+	header string
+	footer string
+	indent string
+}
+
+// fenceWrappers are tried in turn, the first the code parses with is used
+var fenceWrappers = []fenceWrapper{
+	{},                        // a file, with a package clause
+	{header: "package p\n\n"}, // declarations
+	{header: "package p\n\nfunc _() {\n", footer: "}\n", indent: "\t"}, // statements
+}
+
+// wrap returns code as a Go file
+func (w fenceWrapper) wrap(code string) string {
+	return w.header + indentLines(code, w.indent) + w.footer
+}
+
+// unwrap returns the code of out, the formatted output of a wrapped
+// block, if the formatter left the wrapper alone
+func (w fenceWrapper) unwrap(out string) (string, bool) {
+	if !strings.HasPrefix(out, w.header) || !strings.HasSuffix(out, w.footer) || len(out) < len(w.header)+len(w.footer) {
+		return "", false
+	}
+	lines := splitLines(out[len(w.header) : len(out)-len(w.footer)])
+	for i, l := range lines {
+		lines[i] = strings.TrimPrefix(l, w.indent)
+	}
+	return strings.Join(lines, ""), true
+}
+
+// indentLines adds indent to each line of code that is not blank
+func indentLines(code string, indent string) string {
+	if len(indent) == 0 {
+		return code
+	}
+	lines := splitLines(code)
+	for i, l := range lines {
+		if len(strings.TrimSpace(l)) > 0 {
+			lines[i] = indent + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// formatMarkdown formats the Go code blocks of src, a Markdown file named
+// filename.  Blocks can be a whole file, declarations or statements, and
+// the pragmas in them are honored.  Blocks that are none of these, such
+// as those eliding code with ..., are left as they are.
+func (f *Formatter) formatMarkdown(ctx context.Context, src []byte, filename string) ([]byte, error) {
+	e := f.endings(src)
+	lines := splitLines(string(e.normalize(src)))
+
+	var b strings.Builder
+	next := 0
+	for _, fc := range findFences(lines) {
+		if !fc.goCode || fc.start == fc.end {
+			continue
+		}
+		for _, l := range lines[next:fc.start] {
+			b.WriteString(l)
+		}
+		next = fc.end
+
+		var code strings.Builder
+		for _, l := range lines[fc.start:fc.end] {
+			code.WriteString(strings.TrimPrefix(l, fc.indent))
+		}
+		out, err := f.formatFence(ctx, code.String(), filename, fc.start+1)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(indentLines(out, fc.indent))
+	}
+	for _, l := range lines[next:] {
+		b.WriteString(l)
+	}
+	return e.restore([]byte(b.String())), nil
+}
+
+// formatFence formats code, a code block starting on line of filename.
+// Code that does not parse is returned as it is.
+func (f *Formatter) formatFence(ctx context.Context, code string, filename string, line int) (string, error) {
+	for _, w := range fenceWrappers {
+		src := w.wrap(code)
+		_, err := goparser.ParseFile(token.NewFileSet(), "", src, goparser.ParseComments)
+		if err != nil {
+			continue
+		}
+
+		res, err := f.formatSource(ctx, []byte(src), filename)
+		if err != nil {
+			return "", fmt.Errorf("code block at line %d: %w", line, err)
+		}
+		out, ok := w.unwrap(string(res.Output))
+		if !ok {
+			return code, nil
+		}
+		return out, nil
+	}
+	return code, nil
+}
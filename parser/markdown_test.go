@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindFences(t *testing.T) {
+	a := assert.New(t)
+
+	lines := splitLines("# a\n\n```go\nvar a int\n```\n\n````\n```go\n````\n\n- item\n\n  ~~~ golang {.x}\n  x\n  ~~~\n\n`` `go` ``\n\n```go\nunclosed\n")
+	a.Equal([]fence{
+		{start: 3, end: 4, goCode: true},
+		{start: 7, end: 8},
+		{start: 13, end: 14, indent: "  ", goCode: true},
+	}, findFences(lines))
+}
+
+func TestFormatMarkdown(t *testing.T) {
+	src := "# Example\n\nA file:\n\n```go\npackage main\n\nfunc main() {\n    println( \"hi\" )\n}\n```\n\n" +
+		"Declarations:\n\n```go\nvar a   int\n\n// go:nofmt\nvar b   int\n// go:fmt\n```\n\n" +
+		"Statements, in a list:\n\n1. Step\n\n   ```go\n   x:=1\n   s := `a\n     b`\n   ```\n\n" +
+		"Not Go, or not formatted:\n\n```\nvar c   int\n```\n\n```go\nfor ... {\n```\n\n```go\n```\n"
+	out := "# Example\n\nA file:\n\n```go\npackage main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n```\n\n" +
+		"Declarations:\n\n```go\nvar a int\n\n// go:nofmt\nvar b   int\n// go:fmt\n```\n\n" +
+		"Statements, in a list:\n\n1. Step\n\n   ```go\n   x := 1\n   s := `a\n     b`\n   ```\n\n" +
+		"Not Go, or not formatted:\n\n```\nvar c   int\n```\n\n```go\nfor ... {\n```\n\n```go\n```\n"
+
+	a := assert.New(t)
+	f := New()
+	res, err := f.Format([]byte(src), "README.md")
+	a.NoError(err)
+	a.Equal(out, string(res.Output))
+	a.True(res.Changed)
+
+	res, err = f.Format([]byte(strings.ReplaceAll(src, "\n", "\r\n")), "README.md")
+	a.NoError(err)
+	a.Equal(strings.ReplaceAll(out, "\n", "\r\n"), string(res.Output))
+
+	f.Filename = "doc.markdown"
+	var buf, errOut bytes.Buffer
+	a.NoError(f.FormatReader(strings.NewReader(src), &buf, &errOut))
+	a.Equal(out, buf.String())
+	a.Equal(src, string(f.SourceData()))
+
+	// a formatter that breaks the pragmas
+	f = NewFormatter("sed /go:fmt/d")
+	_, err = f.Format([]byte("# a\n\n```go\n// go:nofmt\nvar a = 1\n// go:fmt\n```\n"), "a.md")
+	var mismatch *BlockMismatchError
+	a.True(errors.As(err, &mismatch))
+	a.Contains(err.Error(), "code block at line 4")
+}
@@ -3,21 +3,29 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 var (
 	// DefaultFmter is the default 'fmt' program The format
 	// program will take either a file name or standard in and
-	// format it into Golang syntax Arguments are separated by
-	// spaces.  If %f appears in the argument string will be
+	// format it into Golang syntax Arguments are split as by
+	// ParseCommand.  If %f appears in an argument it will be
 	// replaced by the filename.  If the file is stdandard input
 	// no file will provided.
 	DefaultFmter = "gofmt"
+
+	// killWaitDelay is how long to wait for the output of a formatter to
+	// close after it has been killed
+	killWaitDelay = time.Second
 )
 
 // block will contains slices of the file to be formatted.  A file can
@@ -26,15 +34,42 @@ var (
 type block struct {
 	formatted bool
 	lines     []string
+	synthetic bool // unformatted block added by FormatLines, its pragmas are dropped
+	keepFirst bool // keep the original first line, an unselected pragma in FormatLines
+	keepLast  bool // keep the original last line, an unselected pragma in FormatLines
 }
 
 // Formatter contains information about the file being formatted
 type Formatter struct {
 	file      string       // name of file, blank for standard in
 	formatter string       // formatter with arguments
+	command   []string     // formatter program and arguments, used in place of formatter if set
 	original  []*block     // original file with formatted and unformatted blocks (note: formatted blocks are to be formatted)
 	processed []*block     // post processed file with formatted and unformatted blocks
 	srcData   bytes.Buffer // original source data of file
+
+	// Filename is the name of the source read by FormatReader and
+	// FormatLines.  The file need not exist, but the formatter is run in
+	// its directory if that does, and it is used for %n and %d.
+	Filename string
+
+	// Env holds extra environment variables for the formatter, each of
+	// the form "key=value"
+	Env []string
+
+	// SkipCheck turns off checking that the output is the same program
+	// as the source, see EquivalenceError
+	SkipCheck bool
+
+	// ForceLF ends the lines of the output with \n.  Otherwise the output
+	// keeps the line endings of the source, \r\n if most of its lines end
+	// with it.  A byte order mark at the start of the source is kept.
+	ForceLF bool
+
+	// Embedded formats the contents of the raw strings marked with
+	// // go:fmt-embedded <name>, by name, in addition to the built in
+	// "json", see FormatJSON
+	Embedded map[string]EmbeddedFormatter
 }
 
 // file is a path to file that needs fmting.  If file is empty, stdin is assumed
@@ -49,7 +84,8 @@ func New() *Formatter {
 // NewFormatter returns a Formatter object
 // Formatter program options.  Replace %f with filename if present or append if not.
 // examples: "gofmt %f", "gofmt", "/home/go/bin/goimports", "myfmttool -f %f -pretty"
-// If stdin is used in %f will br replaced with a enpty string
+// Arguments can be quoted, or given as a JSON array, see ParseCommand.
+// If stdin is used an argument of only %f is dropped
 func NewFormatter(formatter string) *Formatter {
 	if len(formatter) == 0 {
 		return New()
@@ -60,21 +96,39 @@ func NewFormatter(formatter string) *Formatter {
 	}
 }
 
+// NewFormatterArgs returns a Formatter object running the program
+// command[0] with the rest of command as its arguments.  %f is replaced
+// as by NewFormatter.
+func NewFormatterArgs(command []string) *Formatter {
+	if len(command) == 0 {
+		return New()
+	}
+
+	return &Formatter{
+		formatter: strings.Join(command, " "),
+		command:   command,
+	}
+}
+
 // FormatFile will write fmted output from file to the out io.Writer
 // If there are syntax errors in the file and it can not be formatted, then error text will be written to errOut
 // An error can be returned without any data being written to errOur
 // Format will scan file for pramga codes // go:nofmt and // go:fmt
 func (f *Formatter) FormatFile(file string, out io.Writer, errOut io.Writer) error {
+	return f.FormatFileContext(context.Background(), file, out, errOut)
+}
 
-	f.file = file
-
+// FormatFileContext is FormatFile with a context.  If ctx is done before
+// the formatter finishes, the formatter is killed and the error of ctx is
+// returned.
+func (f *Formatter) FormatFileContext(ctx context.Context, file string, out io.Writer, errOut io.Writer) error {
 	// open source file
-	fp, err := os.Open(f.file)
+	fp, err := os.Open(file)
 	if err != nil {
 		return err
 	}
 	defer fp.Close()
-	return f.FormatReader(fp, out, errOut)
+	return f.formatReader(ctx, file, fp, out, errOut)
 }
 
 // FormatReader will write fmted output from reader to to the out io.Writer
@@ -82,42 +136,158 @@ func (f *Formatter) FormatFile(file string, out io.Writer, errOut io.Writer) err
 // An error can be returned without any data being written to errOur
 // Format will scan file for pramga codes // go:nofmt and // go:fmt
 func (f *Formatter) FormatReader(in io.Reader, out io.Writer, errOut io.Writer) error {
+	return f.FormatReaderContext(context.Background(), in, out, errOut)
+}
+
+// FormatReaderContext is FormatReader with a context.  If ctx is done
+// before the formatter finishes, the formatter is killed and the error of
+// ctx is returned.
+func (f *Formatter) FormatReaderContext(ctx context.Context, in io.Reader, out io.Writer, errOut io.Writer) error {
+	return f.formatReader(ctx, "", in, out, errOut)
+}
+
+// formatReader formats the source read from in.  If file is not blank it
+// is given to the formatter in place of standard input.
+func (f *Formatter) formatReader(ctx context.Context, file string, in io.Reader, out io.Writer, errOut io.Writer) error {
+	f.file = file
+	f.srcData.Reset()
 	_, err := io.Copy(&f.srcData, in)
 	if err != nil {
 		return err
 	}
 
+	src := f.srcData.Bytes()
+	name := file
+	if len(name) == 0 {
+		name = f.Filename
+	}
+	if formatted, ok, err := f.formatByName(ctx, src, name); ok {
+		f.original, f.processed = nil, nil
+		if synErr, ok := err.(*SyntaxError); ok && len(name) > 0 {
+			return nameSyntaxError(synErr, name)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(formatted)
+		return err
+	}
+
+	e := f.endings(src)
+	norm := e.normalize(src)
+	lx := lexerFor(name)
+	name = f.Filename
+	if len(norm) != len(src) && len(file) > 0 {
+		// the formatter is given the normalized source, not the file
+		name, file = file, ""
+	}
+
+	f.original, f.processed, err = f.formatBlocks(ctx, file, name, norm, errOut)
+	if err != nil {
+		if synErr, ok := err.(*SyntaxError); ok && len(f.file) > 0 {
+			for i := range synErr.Errors {
+				if synErr.Errors[i].File == stdinName {
+					synErr.Errors[i].File = f.file
+				}
+			}
+		}
+		return err
+	}
+	return f.mergeChecked(ctx, norm, e, lx, f.original, f.processed, out)
+}
+
+// formatBlocks runs the formatter on src, returning the blocks of src and
+// of the formatter output.  If file is blank src is piped to the
+// formatter, named name.  Only the formatter program and environment of f
+// are used, so it is safe to call concurrently.
+func (f *Formatter) formatBlocks(ctx context.Context, file string, name string, src []byte, errOut io.Writer) ([]*block, []*block, error) {
+	lx := lexerFor(name)
+	if len(file) > 0 {
+		lx = lexerFor(file)
+	}
+
 	// Read the source file and determine nofmt blocks
 	// all blocks will be unformtted, but marked formatted or unformatted blocks
-	orig := bufio.NewReader(bytes.NewBuffer(f.srcData.Bytes()))
-	f.original, _ = readFile(orig)
+	original, _ := readFile(bufio.NewReader(bytes.NewReader(src)), lx)
 
 	// run "fmt" on the file
-	formatted, err := f.fmtFile(errOut)
+	formatted, err := f.fmtFile(ctx, file, name, src, errOut)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// prococess the fmt file into formated and unformatted blocks
 	// all blocks will be formtted, but marked formatted or unformatted blocks
-	proc := bufio.NewReader(formatted)
-	f.processed, _ = readFile(proc) // there is no way this can fail on a buffer
+	processed, _ := readFile(bufio.NewReader(formatted), lx) // there is no way this can fail on a buffer
+	return original, processed, nil
+}
 
+// Merge will combine src with formatted, the output of any formatter run
+// on src.  Formatted blocks are taken from formatted and the blocks
+// between // go:nofmt and // go:fmt pragmas are taken from src, so the
+// combined output respects the pragmas.  An *EquivalenceError is
+// returned if the result is not the same program as src or formatted.
+func Merge(src []byte, formatted []byte) ([]byte, error) {
+	e := detectEndings(src)
+	src = e.normalize(src)
+	formatted = detectEndings(formatted).normalize(formatted)
+	original, _ := readFile(bufio.NewReader(bytes.NewReader(src)), goLexer)
+	processed, _ := readFile(bufio.NewReader(bytes.NewReader(formatted)), goLexer)
+
+	var out bytes.Buffer
+	err := New().mergeChecked(context.Background(), src, e, goLexer, original, processed, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// merge will write the formatted blocks from processed and the
+// unformatted blocks from original to out
+func merge(original []*block, processed []*block, out io.Writer) error {
 	// We should have the same number of blocks before and after
-	if len(f.original) != len(f.processed) {
-		return fmt.Errorf("block mismatch: %d != %d", len(f.original), len(f.processed))
+	if len(original) != len(processed) {
+		return blockMismatch(original, processed)
 	}
 
 	// Write out the fmtted data.
 	// The formatted blocks from the fmter
 	// The unformatted blocks from the original
-	for i := range f.processed {
+	for i := range processed {
 		var lines []string
-		if f.processed[i].formatted {
-			lines = f.processed[i].lines
+		if processed[i].formatted {
+			lines = processed[i].lines
+			if len(lines) > 0 && (original[i].keepFirst || original[i].keepLast) {
+				lines = append([]string{}, lines...)
+				if original[i].keepFirst {
+					lines[0] = original[i].lines[0]
+				}
+				if original[i].keepLast {
+					lines[len(lines)-1] = original[i].lines[len(original[i].lines)-1]
+				}
+			}
+
+			// the formatter may add blank lines next to a pragma that is not
+			// formatted, such as those FormatLines adds, which would change
+			// the lines around the formatted ones
+			if (i > 0 && original[i-1].synthetic) || original[i].keepFirst {
+				lines = trimAddedBlanks(lines, original[i].lines, true)
+			}
+			if (i+1 < len(original) && original[i+1].synthetic) || original[i].keepLast {
+				lines = trimAddedBlanks(lines, original[i].lines, false)
+			}
 		} else {
-			lines = f.original[i].lines
+			lines = original[i].lines
 		}
+
+		// drop the pragmas that FormatLines added around a synthetic block
+		if i+1 < len(original) && original[i+1].synthetic && len(lines) > 0 {
+			lines = lines[:len(lines)-1]
+		}
+		if i > 0 && original[i-1].synthetic && len(lines) > 0 {
+			lines = lines[1:]
+		}
+
 		for l := range lines {
 			out.Write([]byte(lines[l]))
 		}
@@ -125,6 +295,69 @@ func (f *Formatter) FormatReader(in io.Reader, out io.Writer, errOut io.Writer)
 	return nil
 }
 
+// trimAddedBlanks drops the blank lines after the first line of lines, if
+// first, or before the last one, that are not in orig, the lines lines
+// were formatted from
+func trimAddedBlanks(lines []string, orig []string, first bool) []string {
+	blanks := func(l []string) int {
+		n := 0
+		for len(l) > n+1 {
+			line := l[n+1]
+			if !first {
+				line = l[len(l)-n-2]
+			}
+			if len(strings.TrimSpace(line)) > 0 {
+				break
+			}
+			n++
+		}
+		return n
+	}
+	added := blanks(lines) - blanks(orig)
+	if added <= 0 {
+		return lines
+	}
+	if first {
+		return append([]string{lines[0]}, lines[1+added:]...)
+	}
+	return append(append([]string{}, lines[:len(lines)-1-added]...), lines[len(lines)-1])
+}
+
+// mergeChecked merges original and processed, the blocks of src and of
+// the formatter output, as merge, but only writes the output to out if
+// it is the same program as src.  src is normalized, and the output is
+// given the line endings e.  The raw strings marked with
+// // go:fmt-embedded are then formatted, changing processed.  Only Go,
+// lexed by goLexer, is checked and has embedded languages.
+func (f *Formatter) mergeChecked(ctx context.Context, src []byte, e lineEndings, lx *lexer, original []*block, processed []*block, out io.Writer) error {
+	var buf bytes.Buffer
+	err := merge(original, processed, &buf)
+	if err != nil {
+		return err
+	}
+	if lx != goLexer {
+		_, err = out.Write(e.restore(buf.Bytes()))
+		return err
+	}
+	if !f.SkipCheck {
+		err = checkEquivalent(src, joinBlocks(processed), buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	changed, err := f.formatEmbedded(ctx, processed)
+	if err != nil {
+		return err
+	}
+	if changed {
+		buf.Reset()
+		merge(original, processed, &buf)
+	}
+	_, err = out.Write(e.restore(buf.Bytes()))
+	return err
+}
+
 // SourceData returns the original source data
 func (f *Formatter) SourceData() []byte {
 	return f.srcData.Bytes()
@@ -140,9 +373,10 @@ const (
 	BackTick
 )
 
-// readFile will read a go source file and return a set of blocks (collection of lines)
+// readFile will read a source file and return a set of blocks (collection of lines)
 // each block will alternate between formatted and unformatted code.
-func readFile(buf *bufio.Reader) ([]*block, error) {
+// The lines are lexed by lx, the lexer of the language of the file.
+func readFile(buf *bufio.Reader, lx *lexer) ([]*block, error) {
 	curState := Code
 
 	curBlock := &block{
@@ -162,7 +396,10 @@ func readFile(buf *bufio.Reader) ([]*block, error) {
 			if err == io.EOF {
 				err = nil
 			}
-			break
+			// a last line without a newline is still part of the file
+			if err != nil || len(line) == 0 {
+				break
+			}
 		}
 
 		// parse the code line.  After parsing the line the code can be in one of several states:
@@ -171,7 +408,7 @@ func readFile(buf *bufio.Reader) ([]*block, error) {
 		// Fmt:          Found a // go:fmt marker - switch to a formatted block
 		// BlockComment  Inside a block commend /* */ - add code to current block
 		// BackTick      Inside a back tick string `` - add code to current block
-		newState := parseLine(line, curState)
+		newState := lx.parseLine(line, curState)
 		if newState != curState {
 			switch newState {
 			case NoFmt:
@@ -204,11 +441,55 @@ func readFile(buf *bufio.Reader) ([]*block, error) {
 			}
 		}
 		curBlock.lines = append(curBlock.lines, line)
-
 	}
 	return blocks, err
 }
 
+// Problem describes a go:nofmt or go:fmt pragma that is out of place
+type Problem struct {
+	Line    int    // line of the pragma, starting at 1
+	Message string // description of the problem
+}
+
+// CheckPragmas will scan src for go:nofmt and go:fmt pragmas which are
+// not balanced.  A go:fmt without an opening go:nofmt, or a go:nofmt
+// inside of an unformatted block has no effect, while a go:nofmt that
+// is never closed leaves the rest of the file unformatted.
+func CheckPragmas(src []byte) []Problem {
+	var problems []Problem
+
+	curState := Code
+	formatted := true
+	opened := 0
+	for i, line := range splitLines(string(src)) {
+		newState := goLexer.parseLine(line, curState)
+		switch newState {
+		case NoFmt:
+			curState = Code
+			if !formatted {
+				problems = append(problems, Problem{Line: i + 1, Message: "go:nofmt inside of a go:nofmt block"})
+				continue
+			}
+			formatted = false
+			opened = i + 1
+		case Fmt:
+			curState = Code
+			if formatted {
+				problems = append(problems, Problem{Line: i + 1, Message: "go:fmt without a matching go:nofmt"})
+				continue
+			}
+			formatted = true
+		default:
+			curState = newState
+		}
+	}
+	if !formatted {
+		problems = append(problems, Problem{Line: opened, Message: "go:nofmt without a matching go:fmt"})
+		sort.Slice(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	}
+	return problems
+}
+
 type lineState int64
 
 const (
@@ -254,6 +535,9 @@ var (
 				' ':  Indent,
 				'\t': Indent,
 				'/':  BeginSlash,
+				'`':  InBackTick,
+				'"':  InQuote,
+				'\'': InTick,
 			},
 		},
 		BeginSlash: nextState{
@@ -303,7 +587,7 @@ var (
 
 // parseLine will evaluate a line to see if fmt needs to be enabled or disabled
 // curState will be the codeState from the previous line
-func parseLine(line string, curState codeState) codeState {
+func (l *lexer) parseLine(line string, curState codeState) codeState {
 	// We start assuming we are ready for an Indent, or code, unless the previous line
 	// found us in a Block Comment or Back Tick quote.
 	st := Indent
@@ -314,7 +598,7 @@ func parseLine(line string, curState codeState) codeState {
 		st = InBackTick
 	}
 
-	table := stateTable[st]
+	table := l.table[st]
 
 	for i, r := range line {
 		// peer into the state table for the current rune being processed
@@ -352,18 +636,17 @@ func parseLine(line string, curState codeState) codeState {
 			// We hit an end " or or end ' mark.  Before we return to code, we need to make sure the
 			// previous character was not an escape
 			n := 0
-			for j := i - 1; j >= 0; j-- {
-				if line[j] != '\\' {
-				}
+			for j := i - 1; j >= 0 && line[j] == '\\'; j-- {
 				n++
 			}
-			if n&1 == 0 {
-				st = Other
+			if n&1 == 1 {
+				// an escaped quote, still in the string
+				newState = st
 			}
 		}
 		if st != newState {
 			st = newState
-			table = stateTable[st]
+			table = l.table[st]
 		}
 	}
 
@@ -371,41 +654,67 @@ func parseLine(line string, curState codeState) codeState {
 }
 
 // run the fmter of the source file and capture the output
-func (f *Formatter) fmtFile(errOut io.Writer) (*bytes.Buffer, error) {
+// If file is empty, src is passed to the fmter on stdin, and name, if
+// not empty, is the name of the source.  The fmter is run in the
+// directory of the file or name.
+func (f *Formatter) fmtFile(ctx context.Context, file string, name string, src []byte, errOut io.Writer) (*bytes.Buffer, error) {
+
+	command := f.command
+	if command == nil {
+		var err error
+		command, err = ParseCommand(f.formatter)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// add the file to the formatter
-	formatter := strings.TrimSpace(f.formatter)
-	if strings.Contains(f.formatter, "%f") {
-		formatter = strings.Replace(formatter, "%f", f.file, -1)
-	} else {
-		formatter = formatter + " " + f.file
+	if len(file) > 0 {
+		name = file
 	}
-	formatter = strings.TrimSpace(formatter)
+	dir := sourceDir(name)
 
-	// split the command line in two to get the command
-	cmdArgs := strings.SplitN(formatter, " ", 2)
+	// add the file to the formatter, in its directory it is just the base name
+	fileArg := file
+	if len(dir) > 0 && len(file) > 0 {
+		fileArg = filepath.Base(file)
+	}
+	args := commandArgs(command, fileArg, name, dir)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no formatter program")
+	}
+	formatter := strings.Join(args, " ")
 
-	// split the args in an array
-	var args []string
-	if len(cmdArgs) > 1 {
-		args = strings.Split(cmdArgs[1], " ")
+	// a relative program path would otherwise be found from dir
+	if len(dir) > 0 && strings.ContainsRune(args[0], filepath.Separator) && !filepath.IsAbs(args[0]) {
+		program, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		args[0] = program
 	}
 
-	cmd := exec.Command(cmdArgs[0], args...)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	if len(f.Env) > 0 {
+		cmd.Env = append(os.Environ(), f.Env...)
+	}
+	// once killed, do not wait on children of the formatter holding its output open
+	cmd.WaitDelay = killWaitDelay
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	errChan := make(chan error)
+	// buffered, so the copy can finish if the formatter is killed first
+	errChan := make(chan error, 1)
 
 	// If no file was provided, then need to copy stdin to program
 	// since this blocking, need to start up a go func to do the copy and close when done
-	if f.file == "" {
+	if file == "" {
 		stdin, err := cmd.StdinPipe()
 		go func() {
 			if err == nil {
-				_, err = io.Copy(stdin, bytes.NewBuffer(f.srcData.Bytes()))
+				_, err = io.Copy(stdin, bytes.NewBuffer(src))
 				stdin.Close()
 			}
 			errChan <- err
@@ -415,13 +724,42 @@ func (f *Formatter) fmtFile(errOut io.Writer) (*bytes.Buffer, error) {
 	}
 
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s: %w", formatter, ctx.Err())
+	}
 	errOut.Write(stderr.Bytes())
 	if err != nil || stderr.Len() > 0 {
-		return nil, fmt.Errorf("%s: returned error%s", formatter, errStr(err))
+		execErr := &FormatterExecError{Command: formatter, Stderr: stderr.String(), Err: err}
+		if errs := parseSourceErrors(execErr.Stderr); len(errs) > 0 {
+			for i := range errs {
+				if len(file) > 0 && errs[i].File == fileArg {
+					errs[i].File = file
+				}
+			}
+			return nil, &SyntaxError{Errors: errs, Exec: execErr}
+		}
+		return nil, execErr
 	}
 	return &stdout, <-errChan
 }
 
+// sourceDir returns the absolute directory of the source file name, or
+// blank if name is blank or its directory does not exist
+func sourceDir(name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	dir, err := filepath.Abs(filepath.Dir(name))
+	if err != nil {
+		return ""
+	}
+	st, err := os.Stat(dir)
+	if err != nil || !st.IsDir() {
+		return ""
+	}
+	return dir
+}
+
 func errStr(err error) string {
 	var s string
 	if err != nil {
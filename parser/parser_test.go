@@ -3,9 +3,14 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -84,7 +89,7 @@ func TestReadFile(t *testing.T) {
 	fp, err := os.Open("test-files/fmtme.go")
 	buf := bufio.NewReader(fp)
 
-	blocks, err := readFile(buf)
+	blocks, err := readFile(buf, goLexer)
 	t.Log(err)
 	for i, b := range blocks {
 		t.Log(i, b)
@@ -92,6 +97,47 @@ func TestReadFile(t *testing.T) {
 
 }
 
+func TestNoFinalNewline(t *testing.T) {
+	a := assert.New(t)
+
+	blocks, err := readFile(bufio.NewReader(strings.NewReader("package a\n// go:nofmt\nvar a   int")), goLexer)
+	a.NoError(err)
+	if a.Len(blocks, 2) {
+		a.Equal([]string{"package a\n", "// go:nofmt\n"}, blocks[0].lines)
+		a.Equal([]string{"var a   int"}, blocks[1].lines)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		out  string
+	}{
+		{name: "nofmt", src: "package a\n\n// go:nofmt\nvar a   int", out: "package a\n\n// go:nofmt\nvar a   int"},
+		{name: "nofmt after fmt", src: "package a\n\nvar b   int\n\n// go:nofmt\nvar a   int", out: "package a\n\nvar b int\n\n// go:nofmt\nvar a   int"},
+		{name: "nofmt pragma", src: "package a\n\nvar b   int\n\n// go:nofmt", out: "package a\n\nvar b int\n\n// go:nofmt\n"},
+		{name: "fmt", src: "package a\n\nvar a   int", out: "package a\n\nvar a int\n"},
+		{name: "fmt after nofmt", src: "package a\n\n// go:nofmt\nvar a   int\n// go:fmt\nvar b   int", out: "package a\n\n// go:nofmt\nvar a   int\n// go:fmt\nvar b int\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+
+			res, err := Format([]byte(test.src), "")
+			a.NoError(err)
+			a.Equal(test.out, string(res.Output))
+
+			var out, errOut bytes.Buffer
+			a.NoError(New().FormatReader(strings.NewReader(test.src), &out, &errOut))
+			a.Equal(test.out, out.String())
+		})
+	}
+
+	// the last line is not in the lines to format
+	var out, errOut bytes.Buffer
+	a.NoError(New().FormatLines(strings.NewReader("package a\n\nfunc f() {\n\tb :=   1\n\t_  = b\n}"), &out, &errOut, []LineRange{{Start: 4, End: 4}}))
+	a.Equal("package a\n\nfunc f() {\n\tb := 1\n\t_  = b\n}", out.String())
+}
+
 func TestProcessLine(t *testing.T) {
 
 	// go:nofmt
@@ -124,12 +170,16 @@ func TestProcessLine(t *testing.T) {
 		{name: "Tick", in: Code, out: Code, line: `rune := 'x'`},
 		{name: "Tick in tick", in: Code, out: Code, line: `rune := '\''`},
 		{name: "Backslash in tick", in: Code, out: Code, line: `rune := '\\'`},
+		{name: "Escaped quote", in: Code, out: Code, line: `baz := "\" /*"`},
+		{name: "Backslashes before quote", in: Code, out: BlockComment, line: `baz := "\\" /*`},
+		{name: "Quote first", in: Code, out: Code, line: `	"/*", a,`},
+		{name: "BackTick first", in: Code, out: BackTick, line: "	`a /* b"},
 	}
 	// go:fmt
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			out := parseLine(test.line, test.in)
+			out := goLexer.parseLine(test.line, test.in)
 			assert.Equal(t, test.out, out)
 		})
 	}
@@ -143,20 +193,21 @@ func TestFmtFail(t *testing.T) {
 	}
 	var errOut bytes.Buffer
 
-	data, err := f.fmtFile(&errOut)
+	data, err := f.fmtFile(context.Background(), f.file, "", nil, &errOut)
 	assert.Error(t, err)
 	assert.Empty(t, data)
 }
 
 func TestFmtFile(t *testing.T) {
 	t.Run("File", func(t *testing.T) {
+		// the formatter runs in the directory of the file
 		f := Formatter{
 			file:      "test-files/fmtme.go",
-			formatter: "gofmt test-files/fmtme.go",
+			formatter: "gofmt",
 		}
 		var errOut bytes.Buffer
 
-		data, err := f.fmtFile(&errOut)
+		data, err := f.fmtFile(context.Background(), f.file, "", nil, &errOut)
 		assert.NoError(t, err)
 		assert.Empty(t, errOut)
 		assert.NotEmpty(t, data)
@@ -171,9 +222,97 @@ func TestFmtFile(t *testing.T) {
 		}
 		var errOut bytes.Buffer
 
-		data, err := f.fmtFile(&errOut)
+		data, err := f.fmtFile(context.Background(), f.file, "", f.srcData.Bytes(), &errOut)
 		assert.NoError(t, err)
 		assert.Empty(t, errOut)
 		assert.NotEmpty(t, data)
 	})
 }
+
+func TestFormatReaderContext(t *testing.T) {
+	a := assert.New(t)
+
+	src := "package main\nfunc main(){}\n"
+	f := NewFormatter("sleep 10")
+	var out, errOut bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := f.FormatReaderContext(ctx, strings.NewReader(src), &out, &errOut)
+	a.True(errors.Is(err, context.DeadlineExceeded), "%v", err)
+	a.True(time.Since(start) < 5*time.Second)
+	a.Empty(out.String())
+
+	// a formatter whose child keeps the output open
+	script := filepath.Join(t.TempDir(), "hang.sh")
+	a.NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 10 &\nsleep 10\n"), 0755))
+	f = NewFormatter(script)
+	start = time.Now()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = f.FormatReaderContext(ctx, strings.NewReader(src), &out, &errOut)
+	a.True(errors.Is(err, context.DeadlineExceeded), "%v", err)
+	a.True(time.Since(start) < 5*time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = New().FormatFileContext(ctx, "test-files/fmtme.go", &out, &errOut)
+	a.True(errors.Is(err, context.Canceled), "%v", err)
+
+	out.Reset()
+	err = New().FormatFileContext(context.Background(), "test-files/fmtme.go", &out, &errOut)
+	a.NoError(err)
+	a.NotEmpty(out.String())
+}
+
+func TestFormatterReuse(t *testing.T) {
+	a := assert.New(t)
+	f := New()
+
+	for _, src := range []string{"package a\n\nvar a   int\n", "package b\n\nvar b   int\n"} {
+		var out, errOut bytes.Buffer
+		a.NoError(f.FormatReader(strings.NewReader(src), &out, &errOut))
+		a.Equal(src, string(f.SourceData()))
+		a.Equal(strings.Replace(src, "   ", " ", 1), out.String())
+	}
+
+	// a file then standard input
+	var out, errOut bytes.Buffer
+	a.NoError(f.FormatFile("test-files/fmtme.go", &out, &errOut))
+	out.Reset()
+	a.NoError(f.FormatReader(strings.NewReader("package c\n"), &out, &errOut))
+	a.Equal("package c\n", out.String())
+}
+
+func TestCheckPragmas(t *testing.T) {
+	a := assert.New(t)
+
+	src, err := ioutil.ReadFile("test-files/fmtme.go")
+	a.NoError(err)
+
+	problems := CheckPragmas(src)
+	a.Equal([]Problem{
+		{Line: 11, Message: "go:fmt without a matching go:nofmt"},
+		{Line: 25, Message: "go:fmt without a matching go:nofmt"},
+		{Line: 30, Message: "go:nofmt without a matching go:fmt"},
+	}, problems)
+
+	a.Empty(CheckPragmas([]byte("// go:nofmt\nvar a  int\n// go:fmt\n")))
+	a.Equal([]Problem{{Line: 2, Message: "go:nofmt inside of a go:nofmt block"}},
+		CheckPragmas([]byte("// go:nofmt\n// go:nofmt\n// go:fmt\n")))
+}
+
+func TestMerge(t *testing.T) {
+	a := assert.New(t)
+
+	src := "func main() {\n// go:nofmt\nvar a   int\n// go:fmt\nvar b   int\n}\n"
+	formatted := "func main() {\n\t// go:nofmt\n\tvar a int\n\t// go:fmt\n\tvar b int\n}\n"
+
+	out, err := Merge([]byte(src), []byte(formatted))
+	a.NoError(err)
+	a.Equal("func main() {\n\t// go:nofmt\nvar a   int\n\t// go:fmt\n\tvar b int\n}\n", string(out))
+
+	_, err = Merge([]byte(src), []byte("func main() {\n}\n"))
+	a.Error(err)
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// isTemplate reports if name is a text/template of Go source, of which
+// the Go is formatted around the actions
+func isTemplate(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".go.tmpl")
+}
+
+// action is a {{ }} action of a template, as offsets of its source
+type action struct {
+	start, end int
+}
+
+// findActions returns the actions of src.  The }} of an action is not
+// looked for in its strings and comments.
+func findActions(src string) ([]action, error) {
+	var actions []action
+	for i := 0; i < len(src); {
+		start := strings.Index(src[i:], "{{")
+		if start < 0 {
+			break
+		}
+		start += i
+
+		end := -1
+		inner := strings.TrimLeft(strings.TrimPrefix(src[start+2:], "-"), " \t\r\n")
+		if strings.HasPrefix(inner, "/*") {
+			// a comment, {{/* */}}
+			comment := len(src) - len(inner)
+			if c := strings.Index(src[comment:], "*/"); c >= 0 {
+				if k := strings.Index(src[comment+c:], "}}"); k >= 0 {
+					end = comment + c + k + 2
+				}
+			}
+		} else {
+			end = actionEnd(src, start+2)
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unclosed action", strings.Count(src[:start], "\n")+1)
+		}
+		actions = append(actions, action{start: start, end: end})
+		i = end
+	}
+	return actions, nil
+}
+
+// actionEnd returns the offset after the }} closing the action of src
+// starting at i, or -1 if there is none
+func actionEnd(src string, i int) int {
+	for i < len(src) {
+		switch c := src[i]; c {
+		case '"', '\'', '`':
+			i++
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' && c != '`' {
+					i++
+				}
+				i++
+			}
+			i++
+		case '}':
+			if strings.HasPrefix(src[i:], "}}") {
+				return i + 2
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// isControl reports if action, such as {{if .A}} or {{$a := .A}}, does
+// not stand for a value in the Go source
+func isControl(action string) bool {
+	inner := strings.TrimLeft(strings.TrimPrefix(action[2:], "-"), " \t\r\n")
+	if strings.HasPrefix(inner, "/*") || strings.HasPrefix(inner, "$") {
+		return true
+	}
+	word := strings.FieldsFunc(inner, func(r rune) bool {
+		return !('a' <= r && r <= 'z')
+	})
+	if len(word) == 0 || !strings.HasPrefix(inner, word[0]) {
+		return false
+	}
+	switch word[0] {
+	case "if", "else", "end", "range", "with", "define", "block", "template", "break", "continue":
+		return true
+	}
+	return false
+}
+
+// formatTemplate formats src, a template of Go source named filename.
+// Each line that only holds actions, such as {{range .Fields}} or
+// {{end}}, is protected as a comment placeholder and put back as it was,
+// as a // go:nofmt block is, and each other action is formatted as an
+// identifier as long as it, so that the code around it is aligned, and
+// then put back.
+func (f *Formatter) formatTemplate(ctx context.Context, src []byte, filename string) ([]byte, error) {
+	e := f.endings(src)
+	text := string(e.normalize(src))
+	actions, err := findActions(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(actions) == 0 {
+		res, err := f.formatSource(ctx, src, filename)
+		return res.Output, err
+	}
+
+	// placeholders start with prefix, which is not in the source
+	prefix := "_tmpl"
+	for strings.Contains(text, prefix) {
+		prefix += "x"
+	}
+
+	var b strings.Builder
+	var lines []string         // the lines of each line placeholder
+	var names, values []string // the identifier and text of each action
+	at := 0
+	for i := 0; i < len(actions); {
+		a := actions[i]
+
+		// a line of only actions, with one that is not a value, such as
+		// {{end}}, or a single action, is protected as a whole
+		lineStart := strings.LastIndex(text[:a.start], "\n") + 1
+		j, end := i, a.end
+		for j+1 < len(actions) && strings.TrimLeft(text[end:actions[j+1].start], " \t") == "" {
+			j++
+			end = actions[j].end
+		}
+		lineEnd := len(text)
+		if n := strings.Index(text[end:], "\n"); n >= 0 {
+			lineEnd = end + n
+		}
+		control := j == i
+		for _, a := range actions[i : j+1] {
+			control = control || isControl(text[a.start:a.end])
+		}
+		if control && strings.TrimSpace(text[lineStart:a.start]) == "" && strings.TrimSpace(text[end:lineEnd]) == "" {
+			b.WriteString(text[at:lineStart])
+			name := prefix + strconv.Itoa(len(lines)) + "_"
+			b.WriteString(text[lineStart:a.start] + "//" + name)
+			lines = append(lines, text[lineStart:lineEnd])
+			at = lineEnd
+			i = j + 1
+			continue
+		}
+
+		b.WriteString(text[at:a.start])
+		name := prefix + strconv.Itoa(len(names)) + "v"
+		if n := a.end - a.start - len(name); n > 0 {
+			name += strings.Repeat("_", n)
+		}
+		b.WriteString(name)
+		names = append(names, name)
+		values = append(values, text[a.start:a.end])
+		at = a.end
+		i++
+	}
+	b.WriteString(text[at:])
+
+	res, err := f.formatSource(ctx, []byte(b.String()), filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// put back the actions, which the formatter must not have dropped
+	out := splitLines(string(res.Output))
+	restored := 0
+	for i, l := range out {
+		code := strings.TrimSpace(l)
+		// the formatter may add a space to the comment, // _tmpl0_
+		code = strings.TrimSpace(strings.TrimPrefix(code, "//"))
+		if !strings.HasPrefix(code, prefix) || !strings.HasSuffix(code, "_") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(code[len(prefix):], "_"))
+		if err == nil && n < len(lines) {
+			out[i] = lines[n] + l[len(strings.TrimRight(l, "\n")):]
+			restored++
+		}
+	}
+	formatted := strings.Join(out, "")
+	for i, name := range names {
+		if strings.Contains(formatted, name) {
+			formatted = strings.Replace(formatted, name, values[i], 1)
+			restored++
+		}
+	}
+	if restored != len(lines)+len(names) {
+		return nil, fmt.Errorf("the formatter lost template actions, not writing it")
+	}
+	return e.restore([]byte(formatted)), nil
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindActions(t *testing.T) {
+	a := assert.New(t)
+
+	src := "a {{.A}} b {{ \"}}\" }} {{- /* }} */ -}} {{`}}`}}"
+	actions, err := findActions(src)
+	a.NoError(err)
+	var text []string
+	for _, act := range actions {
+		text = append(text, src[act.start:act.end])
+	}
+	a.Equal([]string{"{{.A}}", "{{ \"}}\" }}", "{{- /* }} */ -}}", "{{`}}`}}"}, text)
+
+	_, err = findActions("package a\n\nvar a = {{.A\n")
+	a.EqualError(err, "line 3: unclosed action")
+
+	a.True(isControl("{{if .A}}"))
+	a.True(isControl("{{- end -}}"))
+	a.True(isControl("{{/* a */}}"))
+	a.True(isControl("{{$a := .A}}"))
+	a.False(isControl("{{.A}}"))
+	a.False(isControl("{{printf \"%d\" .N}}"))
+	a.False(isControl("{{ index .Ends 0 }}"))
+}
+
+func TestFormatTemplate(t *testing.T) {
+	src := `package {{.Package}}
+
+// {{.Name}} is generated
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}}   {{.Type}}
+{{- end}}
+	count int
+	n  int
+}
+
+func (x   *{{.Name}}) Count() int {
+	{{if .Check}}{{template "check" .}}{{end}}
+	// go:nofmt
+	return x.count+{{.Extra}}
+	// go:fmt
+}
+
+var names = map[string]int{
+	"a": 1,
+	"{{.Name}}":   2,
+}
+`
+	out := `package {{.Package}}
+
+// {{.Name}} is generated
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+	count int
+	n     int
+}
+
+func (x *{{.Name}}) Count() int {
+	{{if .Check}}{{template "check" .}}{{end}}
+	// go:nofmt
+	return x.count+{{.Extra}}
+	// go:fmt
+}
+
+var names = map[string]int{
+	"a":         1,
+	"{{.Name}}": 2,
+}
+`
+	a := assert.New(t)
+	f := New()
+	res, err := f.Format([]byte(src), "a.go.tmpl")
+	a.NoError(err)
+	a.Equal(out, string(res.Output))
+
+	res, err = f.Format([]byte(strings.ReplaceAll(src, "\n", "\r\n")), "a.go.tmpl")
+	a.NoError(err)
+	a.Equal(strings.ReplaceAll(out, "\n", "\r\n"), string(res.Output))
+
+	res, err = f.Format([]byte("package a\n\nvar a   int\n"), "a.go.tmpl")
+	a.NoError(err)
+	a.Equal("package a\n\nvar a int\n", string(res.Output))
+
+	_, err = f.Format([]byte("package a\n\nvar a = {{.A\n"), "a.go.tmpl")
+	a.EqualError(err, "a.go.tmpl: line 3: unclosed action")
+
+	// gofmt writes the placeholder of a line before a } as // _tmpl0_
+	res, err = f.Format([]byte("package a\n\ntype T struct {\n{{range .Fields}}\n\tA  int\n{{end}}\n}\n"), "a.go.tmpl")
+	a.NoError(err)
+	a.Equal("package a\n\ntype T struct {\n{{range .Fields}}\n\tA int\n{{end}}\n}\n", string(res.Output))
+
+	res, err = f.Format([]byte("package a\n\nfunc f() {\n{{- if .A}}\n\tprintln(1,  2)\n{{- end}}\n}\n"), "a.go.tmpl")
+	a.NoError(err)
+	a.Equal("package a\n\nfunc f() {\n{{- if .A}}\n\tprintln(1, 2)\n{{- end}}\n}\n", string(res.Output))
+
+	// a formatter that drops the placeholder of a line
+	_, err = NewFormatter("grep -v _tmpl0_").Format([]byte("package a\n\n{{if .A}}\nvar a int\n{{end}}\n"), "a.go.tmpl")
+	a.EqualError(err, "a.go.tmpl: the formatter lost template actions, not writing it")
+}
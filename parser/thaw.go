@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+)
+
+// Thaw returns src without the // go:nofmt and // go:fmt pragmas of the
+// regions the formatter would leave as they are anyway, and the lines of
+// src, from the go:nofmt to the go:fmt, of each region thawed.  A region
+// is only thawed if formatting src without its pragmas gives the same
+// output, less the pragmas, as formatting src, so pragmas which keep the
+// lines around them from being aligned with it are kept.
+func (f *Formatter) Thaw(ctx context.Context, src []byte, filename string) ([]byte, []LineRange, error) {
+	e := f.endings(src)
+	norm := e.normalize(src)
+	original, processed, err := f.formatBlocks(ctx, "", filename, norm, ioutil.Discard)
+	if err != nil {
+		return nil, nil, nameErrors(err, filename)
+	}
+	if len(original) != len(processed) {
+		return nil, nil, nameErrors(blockMismatch(original, processed), filename)
+	}
+	if lexerFor(filename) == goLexer {
+		_, err = f.formatEmbedded(ctx, processed)
+		if err != nil {
+			return nil, nil, nameErrors(err, filename)
+		}
+	}
+
+	// try thawing every region, keeping the pragmas of those that change
+	// the output until it is the same
+	thaw := make([]bool, len(original))
+	n := 0
+	for i, b := range original {
+		if !b.formatted {
+			thaw[i] = true
+			n++
+		}
+	}
+
+	for n > 0 {
+		thawed, _ := thawBlocks(original, original, thaw)
+		want, lines := thawBlocks(original, processed, thaw)
+		res, err := f.FormatContext(ctx, thawed, filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		if bytes.Equal(res.Output, want) {
+			break
+		}
+
+		// keep the pragmas of the regions the output differs within, or
+		// failing that the first
+		edits := Edits(want, res.Output)
+		kept := false
+		for i := range thaw {
+			if !thaw[i] {
+				continue
+			}
+			for _, ed := range edits {
+				if ed.Start < lines[i].End && ed.End >= lines[i].Start {
+					thaw[i] = false
+					kept = true
+					n--
+					break
+				}
+			}
+		}
+		for i := 0; !kept && i < len(thaw); i++ {
+			if thaw[i] {
+				thaw[i] = false
+				kept = true
+				n--
+			}
+		}
+	}
+	if n == 0 {
+		return src, nil, nil
+	}
+
+	var ranges []LineRange
+	line := 1
+	for i, b := range original {
+		if thaw[i] {
+			r := LineRange{Start: line - 1, End: line + len(b.lines)}
+			if i == len(original)-1 {
+				r.End--
+			}
+			ranges = append(ranges, r)
+		}
+		line += len(b.lines)
+	}
+	thawed, _ := thawBlocks(original, original, thaw)
+	return e.restore(thawed), ranges, nil
+}
+
+// thawBlocks joins the unformatted blocks of original and the formatted
+// blocks of processed, as merge, without the pragmas of the unformatted
+// blocks set in thaw.  The lines of the output, starting at 1, each
+// unformatted block ends up on are also returned.
+func thawBlocks(original []*block, processed []*block, thaw []bool) ([]byte, []LineRange) {
+	var b strings.Builder
+	lines := make([]LineRange, len(original))
+	line := 1
+	for i := range original {
+		blockLines := original[i].lines
+		if original[i].formatted {
+			blockLines = processed[i].lines
+		}
+		if i > 0 && thaw[i-1] && len(blockLines) > 0 {
+			blockLines = blockLines[1:] // go:fmt
+		}
+		if i+1 < len(original) && thaw[i+1] && len(blockLines) > 0 {
+			blockLines = blockLines[:len(blockLines)-1] // go:nofmt
+		}
+		lines[i] = LineRange{Start: line, End: line + len(blockLines) - 1}
+		for _, l := range blockLines {
+			b.WriteString(l)
+		}
+		line += len(blockLines)
+	}
+	return []byte(b.String()), lines
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThaw(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		out    string
+		ranges []LineRange
+	}{
+		{
+			name: "no pragmas",
+			src:  "package a\n\nvar a   int\n",
+			out:  "package a\n\nvar a   int\n",
+		},
+		{
+			name: "redundant",
+			src: `package a
+
+func f() {
+	// go:nofmt
+	a := 1
+	// go:fmt
+	b  := 2
+	_, _ = a, b
+}
+`,
+			out: `package a
+
+func f() {
+	a := 1
+	b  := 2
+	_, _ = a, b
+}
+`,
+			ranges: []LineRange{{Start: 4, End: 6}},
+		},
+		{
+			name: "needed",
+			src: `package a
+
+func f() {
+	// go:nofmt
+	a   := 1
+	bcd := 2
+	// go:fmt
+	_, _ = a, bcd
+}
+`,
+			out: `package a
+
+func f() {
+	// go:nofmt
+	a   := 1
+	bcd := 2
+	// go:fmt
+	_, _ = a, bcd
+}
+`,
+		},
+		{
+			name: "keeps alignment apart",
+			src: `package a
+
+var (
+	a = 1
+	// go:nofmt
+	bbbb = 2
+	// go:fmt
+)
+`,
+			out: `package a
+
+var (
+	a = 1
+	// go:nofmt
+	bbbb = 2
+	// go:fmt
+)
+`,
+		},
+		{
+			name: "some",
+			src: `package a
+
+// go:nofmt
+var a = 1
+// go:fmt
+
+// go:nofmt
+var b   = 2
+// go:fmt
+
+// go:nofmt
+var c = 3
+`,
+			out: `package a
+
+var a = 1
+
+// go:nofmt
+var b   = 2
+// go:fmt
+
+var c = 3
+`,
+			ranges: []LineRange{{Start: 3, End: 5}, {Start: 11, End: 12}},
+		},
+		{
+			name:   "crlf",
+			src:    "package a\r\n\r\n// go:nofmt\r\nvar a = 1\r\n// go:fmt\r\n",
+			out:    "package a\r\n\r\nvar a = 1\r\n",
+			ranges: []LineRange{{Start: 3, End: 5}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := assert.New(t)
+
+			out, ranges, err := New().Thaw(context.Background(), []byte(test.src), "a.go")
+			a.NoError(err)
+			a.Equal(test.out, string(out))
+			a.Equal(test.ranges, ranges)
+		})
+	}
+
+	_, _, err := New().Thaw(context.Background(), []byte("package a\n\nvar a = \n"), "a.go")
+	var synErr *SyntaxError
+	assert.True(t, errors.As(err, &synErr))
+	assert.Equal(t, "a.go", synErr.Errors[0].File)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// killWait is how long past the timeout of a request to wait for the
+// daemon to stop the formatter and answer
+const killWait = 5 * time.Second
+
+// daemonRequest asks nofmt serve to format content.  Requests and
+// responses are sent as one JSON object per line, and any number of
+// requests can be sent on a connection.
+type daemonRequest struct {
+	Filename  string   `json:"filename,omitempty"`   // absolute name of the file, used as by -stdin-filename
+	Content   string   `json:"content"`              // source to format
+	Formatter string   `json:"formatter,omitempty"`  // as -F, the formatter of nofmt serve if empty
+	Env       []string `json:"env,omitempty"`        // as -env
+	Embedded  []string `json:"embedded,omitempty"`   // as -embedded
+	Lines     string   `json:"lines,omitempty"`      // as -lines
+	Timeout   string   `json:"timeout,omitempty"`    // as -timeout
+	SkipCheck bool     `json:"skip_check,omitempty"` // as -ast-check=off
+	ForceLF   bool     `json:"force_lf,omitempty"`   // as -force-lf
+}
+
+// daemonResponse is the answer to a daemonRequest
+type daemonResponse struct {
+	Output  string               `json:"output"`
+	Changed bool                 `json:"changed"`
+	Error   string               `json:"error,omitempty"`
+	Errors  []parser.SourceError `json:"errors,omitempty"` // errors in the source, if Error is a syntax error
+}
+
+// socketPath returns the path of the socket of nofmt serve,
+// $NOFMTSOCKET or nofmt.sock in the cache directory
+func socketPath() (string, error) {
+	if path := os.Getenv("NOFMTSOCKET"); len(path) > 0 {
+		return path, nil
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nofmt.sock"), nil
+}
+
+// serveMain runs nofmt as a daemon formatting files sent to a Unix socket
+func serveMain(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	formatter := f.String("F", "gofmt %f", "specify formatter 'program args' for requests that do not give one")
+	socket := f.String("socket", "", "path of the socket, $NOFMTSOCKET or nofmt.sock in the cache directory by default")
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nofmt serve [-F <fmter>] [-socket <path>]\n")
+		f.PrintDefaults()
+	}
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+
+	command, err := parser.ParseCommand(*formatter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: bad formatter: %s\n", err)
+		return 2
+	}
+
+	path := *socket
+	if len(path) == 0 {
+		path, err = socketPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %s\n", err)
+			return 2
+		}
+	}
+	l, err := listenSocket(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %s\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	d := &daemon{command: command}
+	err = d.serve(l)
+	os.Remove(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %s\n", err)
+		return 2
+	}
+	return 0
+}
+
+// listenSocket listens on the Unix socket path, replacing the socket of
+// a daemon that is no longer running.  Anyone who can connect can run
+// formatters, so only the user may use the socket.
+func listenSocket(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("already serving on %s", path)
+	}
+	os.Remove(path)
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// daemon formats the requests sent to nofmt serve
+type daemon struct {
+	command []string // formatter of requests that do not give one
+}
+
+// serve answers the connections to l until it is closed
+func (d *daemon) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go d.handle(conn)
+	}
+}
+
+// handle answers the requests sent on conn until it is closed
+func (d *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req daemonRequest
+		err := dec.Decode(&req)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				enc.Encode(daemonResponse{Error: "bad request: " + err.Error()})
+			}
+			return
+		}
+		if enc.Encode(d.format(req)) != nil {
+			return
+		}
+	}
+}
+
+// format formats the content of req
+func (d *daemon) format(req daemonRequest) daemonResponse {
+	command := d.command
+	if len(req.Formatter) > 0 {
+		var err error
+		command, err = parser.ParseCommand(req.Formatter)
+		if err != nil {
+			return daemonResponse{Error: "bad formatter: " + err.Error()}
+		}
+	}
+
+	ctx := context.Background()
+	if len(req.Timeout) > 0 {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil {
+			return daemonResponse{Error: "bad timeout: " + err.Error()}
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	fmter := parser.NewFormatterArgs(command)
+	fmter.Filename = req.Filename
+	fmter.Env = req.Env
+	fmter.Embedded = embeddedFormatters(req.Embedded)
+	fmter.SkipCheck = req.SkipCheck
+	fmter.ForceLF = req.ForceLF
+
+	var output []byte
+	var err error
+	if len(req.Lines) > 0 {
+		var ranges []parser.LineRange
+		ranges, err = parser.ParseLineRanges(req.Lines)
+		if err != nil {
+			return daemonResponse{Error: err.Error()}
+		}
+		var out bytes.Buffer
+		err = fmter.FormatLinesContext(ctx, strings.NewReader(req.Content), &out, ioutil.Discard, ranges)
+		output = out.Bytes()
+	} else {
+		var res parser.Result
+		res, err = fmter.FormatContext(ctx, []byte(req.Content), req.Filename)
+		output = res.Output
+	}
+
+	if err != nil {
+		resp := daemonResponse{Error: err.Error()}
+		var synErr *parser.SyntaxError
+		if errors.As(err, &synErr) {
+			resp.Errors = synErr.Errors
+			for i, e := range resp.Errors {
+				if e.File == "<standard input>" && len(req.Filename) > 0 {
+					resp.Errors[i].File = req.Filename
+				}
+			}
+		}
+		return resp
+	}
+	return daemonResponse{
+		Output:  string(output),
+		Changed: string(output) != req.Content,
+	}
+}
+
+// daemonClient sends requests to nofmt serve
+type daemonClient struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// dialDaemon connects to nofmt serve, returning nil if it is not running
+func dialDaemon() *daemonClient {
+	path, err := socketPath()
+	if err != nil {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil
+	}
+	return &daemonClient{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(conn),
+	}
+}
+
+// format sends req to the daemon and returns its response.  The daemon
+// applies the timeout of req, so ctx only limits the wait for an answer
+// if the daemon does not.
+func (c *daemonClient) format(ctx context.Context, req daemonRequest) (daemonResponse, error) {
+	var resp daemonResponse
+	deadline := time.Time{}
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d.Add(killWait)
+	}
+	c.conn.SetDeadline(deadline)
+
+	err := c.enc.Encode(req)
+	if err != nil {
+		return resp, err
+	}
+	err = c.dec.Decode(&resp)
+	return resp, err
+}
+
+func (c *daemonClient) close() {
+	c.conn.Close()
+}
+
+// formatLineRanges returns ranges as -lines takes them
+func formatLineRanges(ranges []parser.LineRange) string {
+	s := make([]string, len(ranges))
+	for i, r := range ranges {
+		s[i] = fmt.Sprintf("%d:%d", r.Start, r.End)
+	}
+	return strings.Join(s, ",")
+}
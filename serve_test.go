@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/debspencer/nofmt/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// startDaemon runs nofmt serve on a socket in a temporary directory
+func startDaemon(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "nofmt.sock")
+	t.Setenv("NOFMTSOCKET", path)
+
+	l, err := listenSocket(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go (&daemon{command: []string{"gofmt", "%f"}}).serve(l)
+	return path
+}
+
+func TestServe(t *testing.T) {
+	a := assert.New(t)
+	path := startDaemon(t)
+
+	_, err := listenSocket(path)
+	a.Error(err)
+
+	c := dialDaemon()
+	a.NotNil(c)
+	defer c.close()
+
+	ctx := context.Background()
+	resp, err := c.format(ctx, daemonRequest{Content: "package a\n\nvar a   int\n"})
+	a.NoError(err)
+	a.Equal(daemonResponse{Output: "package a\n\nvar a int\n", Changed: true}, resp)
+
+	resp, err = c.format(ctx, daemonRequest{Content: "package a\n\nvar a   int\nvar b   int\n", Lines: "4"})
+	a.NoError(err)
+	a.Equal("package a\n\nvar a   int\nvar b int\n", resp.Output)
+
+	resp, err = c.format(ctx, daemonRequest{Content: "package a\n", Formatter: "cat"})
+	a.NoError(err)
+	a.Equal(daemonResponse{Output: "package a\n"}, resp)
+
+	resp, err = c.format(ctx, daemonRequest{Filename: "/no/such/dir/b.go", Content: "package b\n\nvar b = \n"})
+	a.NoError(err)
+	a.Equal([]parser.SourceError{{File: "/no/such/dir/b.go", Line: 3, Column: 10, Message: "expected operand, found 'EOF'"}}, resp.Errors)
+	a.NotEmpty(resp.Error)
+
+	resp, err = c.format(ctx, daemonRequest{Content: "package a\n", Formatter: `"gofmt`})
+	a.NoError(err)
+	a.Contains(resp.Error, "bad formatter")
+
+	resp, err = c.format(ctx, daemonRequest{Content: "package a\n", Timeout: "soon"})
+	a.NoError(err)
+	a.Contains(resp.Error, "bad timeout")
+
+	// a request that is not JSON
+	conn, err := net.Dial("unix", path)
+	a.NoError(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("format a.go\n"))
+	a.NoError(err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	a.NoError(err)
+	a.Contains(line, `"error":"bad request`)
+
+	flagErrorHandling = flag.ContinueOnError
+	a.Equal(2, serveMain([]string{"serve", "-no-such-flag"}))
+	a.Equal(2, serveMain([]string{"serve", "-F", `"gofmt`}))
+	a.Equal(2, serveMain([]string{"serve", "-socket", path}))
+}
+
+func TestDaemonClient(t *testing.T) {
+	a := assert.New(t)
+	startDaemon(t)
+
+	file := filepath.Join(t.TempDir(), "a.go")
+	src := "package a\n\nvar a   int\n"
+	a.NoError(ioutil.WriteFile(file, []byte(src), 0644))
+	bad := filepath.Join(filepath.Dir(file), "bad.go")
+	a.NoError(ioutil.WriteFile(bad, []byte("package a\n\nvar b = \n"), 0644))
+
+	opt := getOptions([]string{"nofmt", file})
+	r := &runner{opt: opt, fmter: parser.NewFormatterArgs(opt.command), daemon: dialDaemon()}
+	a.NotNil(r.daemon)
+
+	ctx := context.Background()
+	var out, errOut bytes.Buffer
+	data, err := r.format(ctx, file, nil, &out, &errOut)
+	a.NoError(err)
+	a.Equal(src, string(data))
+	a.Equal("package a\n\nvar a int\n", out.String())
+
+	_, err = r.format(ctx, bad, nil, &out, &errOut)
+	var synErr *parser.SyntaxError
+	a.True(errors.As(err, &synErr))
+	a.Equal(bad+":3:10: expected operand, found 'EOF'", synErr.Error())
+	a.NotNil(r.daemon)
+
+	// without the daemon the file is formatted as usual
+	r.daemon.close()
+	out.Reset()
+	data, err = r.format(ctx, file, nil, &out, &errOut)
+	a.NoError(err)
+	a.Equal(src, string(data))
+	a.Equal("package a\n\nvar a int\n", out.String())
+	a.Nil(r.daemon)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/debspencer/nofmt/parser"
+)
+
+// thawMain removes the pragmas of the regions of files that formatting
+// would leave as they are
+func thawMain(args []string) int {
+	f := flag.NewFlagSet(args[0], flagErrorHandling)
+	list := f.Bool("l", false, "list the regions that would be thawed, without changing the files")
+	formatter := f.String("F", "gofmt %f", "specify formatter 'program args' (filename will be appended unless %f is used)")
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nofmt thaw [-l] [-F <fmter>] file|dir ...\n")
+		f.PrintDefaults()
+	}
+	if f.Parse(args[1:]) != nil {
+		return 2
+	}
+	if f.NArg() == 0 {
+		f.Usage()
+		return 2
+	}
+
+	command, err := parser.ParseCommand(*formatter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thaw: bad formatter: %s\n", err)
+		return 2
+	}
+	fmter := parser.NewFormatterArgs(command)
+
+	files := make(chan string)
+	go func() {
+		walk(files, f.Args(), nil)
+		close(files)
+	}()
+
+	exitCode := 0
+	for file := range files {
+		thawed, err := thawFile(fmter, file, !*list)
+		if err != nil {
+			var synErr *parser.SyntaxError
+			if errors.As(err, &synErr) {
+				printSyntaxError(synErr, file)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			}
+			exitCode = 2
+			continue
+		}
+		if *list {
+			for _, r := range thawed {
+				fmt.Printf("%s:%d-%d\n", file, r.Start, r.End)
+			}
+		}
+	}
+	return exitCode
+}
+
+// thawFile removes the pragmas of the regions of file that formatting
+// would leave as they are, writing it if write is set, and returns the
+// lines of the regions
+func thawFile(fmter *parser.Formatter, file string, write bool) ([]parser.LineRange, error) {
+	st, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	thawed, ranges, err := fmter.Thaw(context.Background(), src, file)
+	if err != nil {
+		return nil, err
+	}
+	if write && !bytes.Equal(thawed, src) {
+		err = ioutil.WriteFile(file, thawed, st.Mode())
+	}
+	return ranges, err
+}
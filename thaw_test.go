@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThaw(t *testing.T) {
+	a := assert.New(t)
+	flagErrorHandling = flag.ContinueOnError
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.go")
+	src := "package a\n\nfunc f() {\n\t// go:nofmt\n\ta := 1\n\t// go:fmt\n\t// go:nofmt\n\tb   := 2\n\t// go:fmt\n\t_, _ = a, b\n}\n"
+	a.NoError(ioutil.WriteFile(file, []byte(src), 0644))
+
+	// -l changes nothing
+	a.Equal(0, thawMain([]string{"thaw", "-l", dir}))
+	data, err := ioutil.ReadFile(file)
+	a.NoError(err)
+	a.Equal(src, string(data))
+
+	a.Equal(0, thawMain([]string{"thaw", dir}))
+	data, err = ioutil.ReadFile(file)
+	a.NoError(err)
+	a.Equal("package a\n\nfunc f() {\n\ta := 1\n\t// go:nofmt\n\tb   := 2\n\t// go:fmt\n\t_, _ = a, b\n}\n", string(data))
+
+	bad := filepath.Join(dir, "bad.go")
+	a.NoError(ioutil.WriteFile(bad, []byte("package a\n\nvar b = \n"), 0644))
+	a.Equal(2, thawMain([]string{"thaw", bad}))
+
+	a.Equal(2, thawMain([]string{"thaw"}))
+	a.Equal(2, thawMain([]string{"thaw", "-no-such-flag"}))
+	a.Equal(2, thawMain([]string{"thaw", "-F", `"gofmt`, dir}))
+}
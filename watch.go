@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileState is what is polled to see if a file has changed
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watch polls files, and the source files below directories as walk finds
+// them, every interval until ctx is done.  process is called for each
+// file that is created or changed, once it has not changed between two
+// polls, so a burst of writes is processed once.  Changes made by
+// process, such as writing the formatted file, are not seen as changes.
+func watch(ctx context.Context, files []string, exts []string, interval time.Duration, process func(file string)) {
+	seen := scanFiles(files, exts)
+	pending := make(map[string]fileState)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := scanFiles(files, exts)
+		for file, st := range current {
+			if st == seen[file] {
+				delete(pending, file)
+				continue
+			}
+			if p, ok := pending[file]; !ok || p != st {
+				pending[file] = st // still being written
+				continue
+			}
+
+			delete(pending, file)
+			process(file)
+			if after, ok := statFile(file); ok {
+				st = after
+			}
+			seen[file] = st
+		}
+
+		for file := range seen {
+			if _, ok := current[file]; !ok {
+				delete(seen, file)
+				delete(pending, file)
+			}
+		}
+	}
+}
+
+// scanFiles returns the state of files, and of the source files below
+// directories as walk finds them
+func scanFiles(files []string, exts []string) map[string]fileState {
+	states := make(map[string]fileState)
+	for _, file := range files {
+		file = trimDots(file)
+		if len(file) == 0 {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if fi.Mode().IsRegular() {
+			states[file] = fileState{fi.Size(), fi.ModTime()}
+			continue
+		}
+		if fi.IsDir() {
+			filepath.Walk(file, func(path string, info os.FileInfo, err error) error {
+				if info != nil && info.Mode().IsRegular() && info.Size() > 0 && sourceFile(path, exts) {
+					states[path] = fileState{info.Size(), info.ModTime()}
+				}
+				return nil
+			})
+		}
+	}
+	return states
+}
+
+// statFile returns the state of file
+func statFile(file string) (fileState, bool) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return fileState{}, false
+	}
+	return fileState{fi.Size(), fi.ModTime()}, true
+}
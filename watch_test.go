@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.go")
+	a.NoError(ioutil.WriteFile(file, []byte("package a\n"), 0644))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes\n"), 0644))
+
+	processed := make(chan string, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watch(ctx, []string{dir + "/..."}, nil, 10*time.Millisecond, func(file string) {
+			// like -w, the file is rewritten when it is processed
+			data, _ := ioutil.ReadFile(file)
+			ioutil.WriteFile(file, append(data, "// formatted\n"...), 0644)
+			processed <- file
+		})
+		close(done)
+	}()
+
+	next := func() string {
+		select {
+		case file := <-processed:
+			return file
+		case <-time.After(200 * time.Millisecond):
+			return ""
+		}
+	}
+
+	// nothing changed yet
+	a.Equal("", next())
+
+	// a burst of writes is processed once
+	for i := 0; i < 3; i++ {
+		a.NoError(ioutil.WriteFile(file, []byte("package a\n\nvar a   int\n"), 0644))
+		a.NoError(os.Chtimes(file, time.Now(), time.Now().Add(time.Duration(i)*time.Second)))
+	}
+	a.Equal(file, next())
+
+	// the write by process is not a change
+	a.Equal("", next())
+
+	// new files are found, other files are not
+	b := filepath.Join(dir, "sub", "b.go")
+	a.NoError(os.Mkdir(filepath.Dir(b), 0755))
+	a.NoError(ioutil.WriteFile(b, []byte("package sub\n"), 0644))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("more notes\n"), 0644))
+	a.Equal(b, next())
+	a.Equal("", next())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch did not stop")
+	}
+}
+
+func TestScanFiles(t *testing.T) {
+	a := assert.New(t)
+
+	files := scanFiles([]string{"...", "nofmt.go", "no/such/file"}, nil)
+	a.Contains(files, "nofmt.go")
+	a.Contains(files, "watch.go")
+	a.NotContains(files, "README.md")
+	a.Equal(files, scanFiles([]string{"./..."}, nil))
+
+	files = scanFiles([]string{"."}, []string{".md"})
+	a.NotContains(files, "nofmt.go")
+	a.Contains(files, "README.md")
+	a.NotContains(files, "Makefile")
+}